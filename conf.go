@@ -53,16 +53,24 @@ func loadConf() {
 	}
 }
 
-func loadConfFile() {
-	path := *confFile
+// ExpandHome replaces a leading "~/" in path with the current user's home directory.
+func ExpandHome(path string) (string, error) {
 	if len(path) > 1 && path[0] == '~' && path[1] == '/' {
 		usr, err := user.Current()
 		if err != nil {
-			log.Println("expanding home dir for config path", err)
-			return
+			return path, err
 		}
 		path = filepath.Join(usr.HomeDir, path[2:])
 	}
+	return path, nil
+}
+
+func loadConfFile() {
+	path, err := ExpandHome(*confFile)
+	if err != nil {
+		log.Println("expanding home dir for config path", err)
+		return
+	}
 	f, err := os.Open(path)
 	if err != nil {
 		if !os.IsNotExist(err) {
@@ -18,14 +18,14 @@ func Deps(src *Src, pkg *Pkg) *Result {
 
 	retry := pkg.Flag&MissingDeps != 0
 	missing := make(map[string]struct{}, 100)
-	deps(src, pkg, missing)
+	deps(src, pkg.Mod, pkg, missing)
 	if len(missing) == 0 {
 		// all deps found
 		pkg.Flag &^= MissingDeps
 		return nil
 	}
-	if !retry {
-		// flag missing dependencies and retry later
+	if !retry || len(src.pending) > 0 {
+		// flag missing dependencies and retry later, after module dependencies are mounted
 		pkg.Flag |= MissingDeps
 		return nil
 	}
@@ -41,7 +41,9 @@ func Deps(src *Src, pkg *Pkg) *Result {
 	}
 }
 
-func deps(src *Src, pkg *Pkg, missing map[string]struct{}) {
+// deps resolves the imports of pkg. Imports missing in the workspace are looked up
+// in the requirements of the worked module mod and the module of pkg.
+func deps(src *Src, mod *Module, pkg *Pkg, missing map[string]struct{}) {
 	info := pkg.Src.Info
 	if info == nil {
 		return
@@ -50,6 +52,9 @@ func deps(src *Src, pkg *Pkg, missing map[string]struct{}) {
 		imprt := &info.Imports[i]
 		p := src.lookup[imprt.Path]
 		if p == nil || p.Path == "" {
+			if mod != nil && src.require(imprt.Path, mod, pkg.Mod) {
+				continue
+			}
 			missing[imprt.Path] = struct{}{}
 			continue
 		}
@@ -61,6 +66,6 @@ func deps(src *Src, pkg *Pkg, missing map[string]struct{}) {
 		if p.Src.Info == nil {
 			Scan(p)
 		}
-		deps(src, p, missing)
+		deps(src, mod, p, missing)
 	}
 }
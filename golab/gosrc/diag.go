@@ -0,0 +1,82 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gosrc
+
+import (
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/mb0/lab/ws"
+)
+
+// Diagnostic severities.
+const (
+	SevError   = "error"
+	SevWarning = "warning"
+)
+
+// Diag is a diagnostic at a source position reported by the go tool.
+type Diag struct {
+	// Id identifies the file resource at Path.
+	Id   ws.Id
+	Path string
+	Line int
+	// Col is the column starting at 1 or 0 if unknown.
+	Col      int `json:",omitempty"`
+	Msg      string
+	Severity string
+}
+
+var diagpos = regexp.MustCompile(`^\s*((?:[A-Za-z]:)?[^\s:]+\.go):(\d+)(?::(\d+))?: (.*)$`)
+
+// ParseDiags returns the diagnostics of the build, vet or test output out.
+// Relative paths are resolved against dir. Vet reports are warnings and lines
+// indented deeper than a diagnostic continue its message.
+func ParseDiags(out, dir, severity string) []Diag {
+	var list []Diag
+	// cont is the indentation of the last diagnostic or -1 if its message ended
+	cont := -1
+	for _, l := range strings.Split(out, "\n") {
+		sev := severity
+		if strings.HasPrefix(l, "vet: ") {
+			l, sev = l[5:], SevWarning
+		}
+		n := len(l) - len(strings.TrimLeft(l, " \t"))
+		if cont >= 0 && n > cont && n < len(l) {
+			list[len(list)-1].Msg += "\n" + strings.TrimSpace(l)
+			continue
+		}
+		cont = -1
+		m := diagpos.FindStringSubmatch(l)
+		if m == nil {
+			continue
+		}
+		cont = n
+		path := m[1]
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		d := Diag{Id: ws.NewId(path), Path: path, Msg: m[4], Severity: sev}
+		d.Line, _ = strconv.Atoi(m[2])
+		d.Col, _ = strconv.Atoi(m[3])
+		list = append(list, d)
+	}
+	return list
+}
+
+// testDiags returns the diagnostics logged by failed tests in list.
+func testDiags(list []*TestResult, dir string) []Diag {
+	var diags []Diag
+	for _, t := range list {
+		if t.Status != "fail" {
+			continue
+		}
+		diags = append(diags, ParseDiags(t.Output, dir, SevError)...)
+		diags = append(diags, testDiags(t.Tests, dir)...)
+	}
+	return diags
+}
@@ -0,0 +1,88 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gosrc
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func formatDiags(list []Diag) string {
+	res := make([]string, 0, len(list))
+	for _, d := range list {
+		res = append(res, fmt.Sprintf("%s:%d:%d %s %q", d.Path, d.Line, d.Col, d.Severity, d.Msg))
+	}
+	return strings.Join(res, "\n")
+}
+
+func TestParseDiags(t *testing.T) {
+	tests := []struct {
+		out    string
+		sev    string
+		expect []string
+	}{
+		{"# example.com/a\n" +
+			"./a.go:6:7: not enough arguments in call to f\n" +
+			"\thave ()\n" +
+			"\twant (int)\n" +
+			"./a.go:7:9: cannot use x (variable of type int) as string value in return statement\n",
+			SevError, []string{
+				`/src/a/a.go:6:7 error "not enough arguments in call to f\nhave ()\nwant (int)"`,
+				`/src/a/a.go:7:9 error "cannot use x (variable of type int) as string value in return statement"`,
+			}},
+		{"# example.com/a\n" +
+			"a.go:6:14: fmt.Printf format %d has arg s of wrong type string\n",
+			SevWarning, []string{
+				`/src/a/a.go:6:14 warning "fmt.Printf format %d has arg s of wrong type string"`,
+			}},
+		{"vet: ./a.go:6:7: undefined: x\n" +
+			"/abs/b.go:3: no column\n",
+			SevError, []string{
+				`/src/a/a.go:6:7 warning "undefined: x"`,
+				`/abs/b.go:3:0 error "no column"`,
+			}},
+		{"./a.go:12:6: x redeclared in this block\n" +
+			"\t./a.go:11:6: other declaration of x\n" +
+			"too many errors\n" +
+			"\tnot continued\n",
+			SevError, []string{
+				`/src/a/a.go:12:6 error "x redeclared in this block\n./a.go:11:6: other declaration of x"`,
+			}},
+		{"=== RUN   TestA/bad\n" +
+			"        a_test.go:7: bad value\n" +
+			"            want 1\n" +
+			"    --- FAIL: TestA/bad (0.00s)\n",
+			SevError, []string{
+				`/src/a/a_test.go:7:0 error "bad value\nwant 1"`,
+			}},
+		{"ok  \texample.com/a\t0.002s\n", SevError, nil},
+	}
+	for _, test := range tests {
+		got := formatDiags(ParseDiags(test.out, "/src/a", test.sev))
+		if want := strings.Join(test.expect, "\n"); got != want {
+			t.Errorf("parse %q expected\n%s\ngot\n%s", test.out, want, got)
+		}
+	}
+}
+
+func TestTestDiags(t *testing.T) {
+	list := []*TestResult{
+		{Name: "TestPass", Status: "pass", Output: "    a_test.go:5: logged\n"},
+		{Name: "TestA", Status: "fail", Output: "--- FAIL: TestA (0.00s)\n", Tests: []*TestResult{
+			{Name: "TestA/ok", Status: "pass", Output: "        a_test.go:6: logged\n"},
+			{Name: "TestA/bad", Status: "fail", Output: "        a_test.go:7: bad value\n            want 1\n"},
+		}},
+		{Name: "TestB", Status: "fail", Output: "    b_test.go:3: failed\n"},
+	}
+	got := formatDiags(testDiags(list, "/src/a"))
+	want := strings.Join([]string{
+		`/src/a/a_test.go:7:0 error "bad value\nwant 1"`,
+		`/src/a/b_test.go:3:0 error "failed"`,
+	}, "\n")
+	if got != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, got)
+	}
+}
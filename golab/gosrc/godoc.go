@@ -7,12 +7,21 @@ package gosrc
 import (
 	"bytes"
 	"fmt"
+	"go/ast"
 	"go/build"
+	"go/doc"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"html/template"
 	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strings"
+
+	"github.com/mb0/lab/ws"
 )
 
 var godoctool, godoctmpl string
@@ -89,3 +98,121 @@ func findTemplate() string {
 	}
 	return ""
 }
+
+type docDecl struct {
+	Name string
+	Decl string
+	Doc  template.HTML
+	// Src links to the declaration in the source file.
+	Src   string
+	Decls []docDecl
+}
+
+type docView struct {
+	Name       string
+	ImportPath string
+	Doc        template.HTML
+	Consts     []docDecl
+	Vars       []docDecl
+	Funcs      []docDecl
+	Types      []docDecl
+}
+
+var pkgdoc = template.Must(template.New("pkgdoc").Parse(`<div id="pkg-overview">
+<p><code>import "{{.ImportPath}}"</code></p>
+{{.Doc}}
+</div>
+{{with .Consts}}<h2 id="pkg-constants">Constants</h2>{{range .}}{{template "decl" .}}{{end}}{{end}}
+{{with .Vars}}<h2 id="pkg-variables">Variables</h2>{{range .}}{{template "decl" .}}{{end}}{{end}}
+{{range .Funcs}}<h2 id="{{.Name}}">func <a href="{{.Src}}">{{.Name}}</a></h2>{{template "decl" .}}{{end}}
+{{range .Types}}<h2 id="{{.Name}}">type <a href="{{.Src}}">{{.Name}}</a></h2>{{template "decl" .}}
+{{range .Decls}}{{if .Name}}<h3 id="{{.Name}}"><a href="{{.Src}}">{{.Name}}</a></h3>{{end}}{{template "decl" .}}{{end}}
+{{end}}
+{{define "decl"}}<pre>{{.Decl}}</pre>
+{{.Doc}}
+{{end}}`))
+
+// PkgHtmlDoc renders the documentation of package p from its sources in the workspace.
+// Unlike LoadHtmlDoc it works for packages that are not installed or read-only.
+func PkgHtmlDoc(p *Pkg) ([]byte, error) {
+	r := p.Res
+	if r == nil {
+		return nil, fmt.Errorf("package not found")
+	}
+	var paths []string
+	r.Lock()
+	if r.Dir != nil {
+		for _, c := range r.Children {
+			if c.Flag&(ws.FlagDir|FlagGo) == FlagGo && !strings.HasSuffix(c.Name, "_test.go") {
+				paths = append(paths, filepath.Join(r.Dir.Path, c.Name))
+			}
+		}
+	}
+	r.Unlock()
+	fs := r.FS()
+	fset := token.NewFileSet()
+	var files []*ast.File
+	for _, path := range paths {
+		f, err := parseFile(fset, fs, path, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		// files of other packages are usually excluded by build tags
+		if len(files) == 0 || f.Name.Name == files[0].Name.Name {
+			files = append(files, f)
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no go files")
+	}
+	dp, err := doc.NewFromFiles(fset, files, p.Path)
+	if err != nil {
+		return nil, err
+	}
+	conf := printer.Config{Mode: printer.UseSpaces | printer.TabIndent, Tabwidth: 8}
+	decl := func(name string, node ast.Node, text string) docDecl {
+		var buf bytes.Buffer
+		conf.Fprint(&buf, fset, node)
+		pos := fset.Position(node.Pos())
+		return docDecl{
+			Name: name,
+			Decl: buf.String(),
+			Doc:  template.HTML(dp.HTML(text)),
+			Src:  fmt.Sprintf("#file%s#L%d", pos.Filename, pos.Line),
+		}
+	}
+	values := func(list []*doc.Value) (res []docDecl) {
+		for _, v := range list {
+			res = append(res, decl("", v.Decl, v.Doc))
+		}
+		return res
+	}
+	funcs := func(list []*doc.Func) (res []docDecl) {
+		for _, f := range list {
+			res = append(res, decl(f.Name, f.Decl, f.Doc))
+		}
+		return res
+	}
+	view := docView{
+		Name:       dp.Name,
+		ImportPath: dp.ImportPath,
+		Doc:        template.HTML(dp.HTML(dp.Doc)),
+		Consts:     values(dp.Consts),
+		Vars:       values(dp.Vars),
+		Funcs:      funcs(dp.Funcs),
+	}
+	for _, t := range dp.Types {
+		d := decl(t.Name, t.Decl, t.Doc)
+		d.Decls = append(values(t.Consts), values(t.Vars)...)
+		d.Decls = append(d.Decls, funcs(t.Funcs)...)
+		for _, m := range t.Methods {
+			d.Decls = append(d.Decls, decl(t.Name+"."+m.Name, m.Decl, m.Doc))
+		}
+		view.Types = append(view.Types, d)
+	}
+	var buf bytes.Buffer
+	if err := pkgdoc.Execute(&buf, view); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -7,8 +7,11 @@ package gosrc
 import (
 	"fmt"
 	"go/build"
+	"os"
 	"path/filepath"
+	"runtime"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -22,22 +25,57 @@ var FlagGo uint64 = 1 << 16
 
 type Src struct {
 	sync.RWMutex
+	roots  []string
+	work   string
+	env    []string
+	modenv []string
 	srcids []ws.Id
 	pkgs   map[ws.Id]*Pkg
 	lookup map[string]*Pkg
 	queue  *ws.Throttle
-	rmchan chan *ws.Res
+	// rmsig signals removed package dirs to Run without blocking.
+	rmsig chan struct{}
+	ws    *ws.Ws
+	runs  runs
+
+	// modmu guards the modules by root id and the mounted dependency paths.
+	modmu sync.Mutex
+	mods  map[ws.Id]*Module
+	deps  map[ws.Id]string
+	// remods holds the ids of module roots with added, changed or removed go.mod files.
+	remods map[ws.Id]bool
+	// pending holds the module path of dependency dirs to mount.
+	pending map[string]string
+
+	// rmmu guards the ids of removed package dirs to clean up.
+	rmmu    sync.Mutex
+	removed []ws.Id
 
 	reportsignal []func(*Report)
 }
 
+// New returns a source module for the default go source dirs and work flag.
 func New() *Src {
+	return NewRoots(build.Default.SrcDirs(), "")
+}
+
+// NewRoots returns a source module for the go source dirs roots and the work path list.
+// An empty work list defaults to the work flag. Builds use a GOPATH of the roots.
+func NewRoots(roots []string, work string) *Src {
 	s := &Src{
+		roots:  roots,
+		work:   work,
+		env:    gopathEnv(roots),
+		modenv: append(os.Environ(), "GO111MODULE=on"),
 		pkgs:   make(map[ws.Id]*Pkg),
 		lookup: make(map[string]*Pkg),
 		queue:  ws.NewThrottle(time.Second),
-		rmchan: make(chan *ws.Res),
+		rmsig:  make(chan struct{}, 1),
+		mods:   make(map[ws.Id]*Module),
+		deps:   make(map[ws.Id]string),
+		remods: make(map[ws.Id]bool),
 	}
+	s.queue.MaxWait = 10 * time.Second
 	p := Pkg{Id: ws.NewId("C"), Path: "C"}
 	p.Name = "C"
 	p.Src.Info = &Info{}
@@ -47,11 +85,11 @@ func New() *Src {
 
 func (s *Src) Init() {
 	var ids []ws.Id
-	for _, d := range build.Default.SrcDirs() {
+	for _, d := range s.roots {
 		ids = append(ids, ws.NewId(d))
 	}
 	s.srcids = ids
-	for _, p := range filepath.SplitList(*workpaths) {
+	for _, p := range filepath.SplitList(s.workpaths()) {
 		err := s.WorkOn(p)
 		if err != nil {
 			fmt.Println(err)
@@ -63,6 +101,24 @@ func (s *Src) Init() {
 	})
 }
 
+func (s *Src) workpaths() string {
+	if s.work == "" {
+		return *workpaths
+	}
+	return s.work
+}
+
+// SetWs sets the workspace used to mount module dependencies.
+func (s *Src) SetWs(w *ws.Ws) {
+	s.ws = w
+}
+
+// Prioritize sets the function returning the priority class of package directories.
+// Packages with higher classes are worked first and are not delayed by further changes.
+func (s *Src) Prioritize(f func(*ws.Res) int) {
+	s.queue.SetPriority(f)
+}
+
 func (s *Src) Pkg(id ws.Id) *Pkg {
 	s.Lock()
 	defer s.Unlock()
@@ -98,16 +154,23 @@ func (s *Src) Filter(r *ws.Res) bool {
 	if r.Flag&ws.FlagDir == 0 {
 		if filepath.Ext(r.Name) == ".go" {
 			r.Flag |= FlagGo
+		} else if r.Name == "go.mod" && r.Parent.Flag&FlagMod == 0 {
+			// subdirs are read and filtered before the files
+			r.Parent.Flag |= FlagGo | FlagMod
+			markGo(r.Parent, nil)
 		}
 		return false
 	}
-	if r.Parent.Flag&FlagGo != 0 {
-		if r.Name != "testdata" && r.Name[0] != '_' {
-			r.Flag |= FlagGo
+	switch {
+	case r.Flag&(ws.FlagMount|ws.FlagReadOnly) == ws.FlagMount|ws.FlagReadOnly:
+		// read-only mounts are source dirs
+		r.Flag |= FlagGo
+	case r.Parent.Flag&FlagGo != 0:
+		if r.Name == "testdata" || r.Name[0] == '_' {
+			return false
 		}
-		return false
-	}
-	if r.Name == "pkg" || r.Name == "src" {
+		r.Flag |= FlagGo
+	case r.Name == "pkg" || r.Name == "src":
 		for _, id := range s.srcids {
 			if r.Id == id {
 				r.Flag |= FlagGo
@@ -115,10 +178,17 @@ func (s *Src) Filter(r *ws.Res) bool {
 			}
 		}
 	}
+	if r.Flag&ws.FlagMount != 0 && s.isdep(r.Id) {
+		r.Flag |= FlagGo | FlagMod
+	}
 	return false
 }
 
 func (s *Src) Handle(op ws.Op, r *ws.Res) {
+	if r.Name == "go.mod" && r.Flag&ws.FlagDir == 0 {
+		s.modfile(op, r.Parent)
+		return
+	}
 	if r.Flag&FlagGo == 0 {
 		return
 	}
@@ -126,13 +196,26 @@ func (s *Src) Handle(op ws.Op, r *ws.Res) {
 		switch op & ws.WsMask {
 		case ws.Change:
 			s.queue.Add(r)
+			s.runs.supersede(r.Id)
 		case ws.Remove:
-			s.rmchan <- r
+			s.runs.supersede(r.Id)
+			// moved resources are queued again with their new id
+			if op&ws.Move == 0 {
+				s.queue.Delete(r)
+			}
+			s.rmmu.Lock()
+			s.removed = append(s.removed, r.Id)
+			s.rmmu.Unlock()
+			select {
+			case s.rmsig <- struct{}{}:
+			default:
+			}
 		}
 		return
 	}
 	if op&ws.FsMask != 0 && r.Parent.Flag&FlagGo != 0 {
 		s.queue.Add(r.Parent)
+		s.runs.supersede(r.Parent.Id)
 	}
 	return
 }
@@ -145,44 +228,71 @@ func (s *Src) Run() {
 			timeout = t.C
 		case <-timeout:
 			s.change(s.queue.Work())
-		case r := <-s.rmchan:
-			s.queue.Delete(r)
-			s.remove(r)
+		case <-s.rmsig:
+			s.Lock()
+			s.remove()
+			s.Unlock()
 		}
 	}
 }
+
+// change scans the packages of batch and their dependents and builds them.
+// Src is only locked while packages are scanned and results are stored.
 func (s *Src) change(batch []*ws.Res) {
 	dirty := make(map[ws.Id]*Pkg)
+	jobs := newJobs()
 	s.Lock()
+	// clean up removals signaled while building
+	s.remove()
+	batch = append(batch, s.repath()...)
+	// create all packages first to find dependencies in the same batch
+	pkgs := make([]*Pkg, 0, len(batch))
 	for _, r := range batch {
-		p := s.getorcreateres(r)
+		pkgs = append(pkgs, s.getorcreateres(r))
+	}
+	for _, p := range pkgs {
 		if p.Flag&Watching != 0 {
-			workAll(s, p, dirty)
+			workAll(s, p, dirty, jobs)
 		}
 	}
-	for _, dirt := range dirty {
-		if dirt != nil {
-			workAll(s, dirt, dirty)
+	// work dependents and retry missing dependencies once
+	retried := make(map[ws.Id]bool)
+	for queued := true; queued; {
+		queued = false
+		for id, dirt := range dirty {
+			if dirt != nil && !retried[id] {
+				retried[id], queued = true, true
+				workAll(s, dirt, dirty, jobs)
+			}
 		}
 	}
+	link(jobs)
 	s.Unlock()
+	s.mountDeps()
+	s.build(jobs)
 	for _, dirt := range dirty {
 		if dirt != nil {
 			s.queue.Add(dirt.Res)
 		}
 	}
 }
-func (s *Src) remove(r *ws.Res) {
-	s.Lock()
-	defer s.Unlock()
-	p, ok := s.pkgs[r.Id]
-	if !ok {
-		return
+
+// remove cleans up the packages of removed dirs. Src must be locked.
+func (s *Src) remove() {
+	s.rmmu.Lock()
+	ids := s.removed
+	s.removed = nil
+	s.rmmu.Unlock()
+	for _, id := range ids {
+		p, ok := s.pkgs[id]
+		if !ok {
+			continue
+		}
+		// clean up p
+		delete(s.pkgs, p.Id)
+		delete(s.lookup, p.Path)
+		// TODO clean up uses in dependencies
 	}
-	// clean up p
-	delete(s.pkgs, p.Id)
-	delete(s.lookup, p.Path)
-	// TODO clean up uses in dependencies
 }
 func (s *Src) getorcreate(id ws.Id, dir string) *Pkg {
 	pkg, ok := s.pkgs[id]
@@ -196,11 +306,11 @@ func (s *Src) getorcreateres(r *ws.Res) *Pkg {
 	pkg := s.getorcreate(r.Id, r.Path())
 	if pkg.Res == nil {
 		pkg.Res = r
-		pkg.Path = importPath(r)
+		pkg.Path, pkg.Mod = s.importPath(r)
 		if s.lookup[pkg.Path] == nil {
 			s.lookup[pkg.Path] = pkg
 		}
-		if r.Parent.Parent.Flag&FlagGo != 0 {
+		if inpkg(r) {
 			pa := s.getorcreateres(r.Parent)
 			if pa.Flag&Recursing != 0 {
 				pkg.Flag |= Working | Watching | Recursing
@@ -222,17 +332,22 @@ func (s *Src) WorkOn(path string) error {
 		return err
 	}
 	id := ws.NewId(p)
+	jobs := newJobs()
 	s.Lock()
-	defer s.Unlock()
 	pkg := s.getorcreate(id, p)
 	pkg.Flag |= flag
 	if pkg.Res != nil {
-		workAll(s, pkg, make(map[ws.Id]*Pkg))
+		workAll(s, pkg, make(map[ws.Id]*Pkg), jobs)
+		link(jobs)
 	}
+	s.Unlock()
+	s.build(jobs)
 	return nil
 }
 
-func work(s *Src, p *Pkg, dirty map[ws.Id]*Pkg) {
+// work scans p and resolves its dependencies. Packages ready to be built are
+// added to jobs and their dependents are marked dirty. Src must be locked.
+func work(s *Src, p *Pkg, dirty map[ws.Id]*Pkg, jobs *jobs) {
 	Scan(p)
 	res := Deps(s, p)
 	if res != nil {
@@ -250,17 +365,12 @@ func work(s *Src, p *Pkg, dirty map[ws.Id]*Pkg) {
 		return
 	}
 	dirty[p.Id] = nil
-	if p.Src.Info != nil {
-		p.Src.Result = Install(p)
-	}
-	if p.Test.Info != nil {
-		p.Test.Result = Test(p)
+	if p.Res.Flag&ws.FlagReadOnly != 0 {
+		// read-only sources are only scanned
+		return
 	}
-	if p.Src.Result != nil || p.Test.Result != nil {
-		rep := NewReport(p)
-		for _, f := range s.reportsignal {
-			f(rep)
-		}
+	if p.Src.Info != nil || p.Test.Info != nil {
+		jobs.add(s.newJob(p))
 	}
 	for _, id := range p.Uses {
 		if _, ok := dirty[id]; !ok {
@@ -268,27 +378,62 @@ func work(s *Src, p *Pkg, dirty map[ws.Id]*Pkg) {
 		}
 	}
 }
-func workAll(s *Src, p *Pkg, dirty map[ws.Id]*Pkg) error {
-	work(s, p, dirty)
+
+func workAll(s *Src, p *Pkg, dirty map[ws.Id]*Pkg, jobs *jobs) error {
+	work(s, p, dirty, jobs)
 	if p.Flag&(Working|Recursing) != 0 {
 		for _, c := range p.Pkgs {
 			if c.Res == nil || c.Flag&(Working|Recursing) != 0 {
 				continue
 			}
 			c.Flag |= Working | Watching | Recursing
-			workAll(s, c, dirty)
+			workAll(s, c, dirty, jobs)
 		}
 	}
 	return nil
 }
 
-func importPath(r *ws.Res) string {
-	return string(scanpath(r, make([]byte, 0, 64)))
+// importPath returns the import path and module of the package dir r. Packages in
+// modules are relative to the nearest module root, others to their go source dir.
+func (s *Src) importPath(r *ws.Res) (string, *Module) {
+	for a, rel := r, ""; a != nil && a.Flag&FlagGo != 0; a = a.Parent {
+		if a.Flag&FlagMod != 0 {
+			if m := s.module(a); m != nil {
+				return m.Path + rel, m
+			}
+		}
+		rel = "/" + a.Name + rel
+	}
+	return string(scanpath(r, make([]byte, 0, 64))), nil
 }
 func scanpath(r *ws.Res, buf []byte) []byte {
-	if r.Parent.Parent.Flag&FlagGo != 0 {
+	if inpkg(r) {
 		buf = scanpath(r.Parent, buf)
 		buf = append(buf, '/')
 	}
 	return append(buf, []byte(r.Name)...)
 }
+
+// inpkg returns whether the parent of package dir r is a package dir.
+// Module roots are not part of their parent package tree.
+func inpkg(r *ws.Res) bool {
+	p := r.Parent
+	if r.Flag&FlagMod != 0 || p == nil || p.Flag&FlagGo == 0 {
+		return false
+	}
+	return p.Flag&FlagMod != 0 || p.Parent != nil && p.Parent.Flag&FlagGo != 0
+}
+
+// gopathEnv returns the environment with a GOPATH of the parents of roots
+// that are not in GOROOT. Packages outside of modules are built in GOPATH mode.
+func gopathEnv(roots []string) []string {
+	goroot := filepath.Clean(runtime.GOROOT())
+	var paths []string
+	for _, root := range roots {
+		dir := filepath.Dir(root)
+		if dir != goroot && !strings.HasPrefix(dir, goroot+string(filepath.Separator)) {
+			paths = append(paths, dir)
+		}
+	}
+	return append(os.Environ(), "GO111MODULE=off", "GOPATH="+strings.Join(paths, string(filepath.ListSeparator)))
+}
@@ -0,0 +1,37 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gosrc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mb0/lab/ws"
+)
+
+func TestHandleRemove(t *testing.T) {
+	s := NewRoots(nil, "")
+	r := &ws.Res{Id: ws.NewId("/src/a"), Name: "a", Flag: ws.FlagDir | FlagGo}
+	p := &Pkg{Id: r.Id, Path: "a"}
+	s.pkgs[p.Id], s.lookup[p.Path] = p, p
+	done := make(chan bool)
+	go func() {
+		// Run is not receiving while packages are built
+		s.Handle(ws.Remove, r)
+		s.Handle(ws.Remove, r)
+		done <- true
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("handling removals blocked")
+	}
+	s.Lock()
+	s.remove()
+	s.Unlock()
+	if s.pkgs[p.Id] != nil || s.lookup[p.Path] != nil {
+		t.Error("removed package not cleaned up")
+	}
+}
@@ -11,7 +11,9 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"strings"
 	"time"
 )
 
@@ -40,11 +42,24 @@ type Result struct {
 	Errmsg string `json:",omitempty"`
 	Stdout string `json:",omitempty"`
 	Stderr string `json:",omitempty"`
+	// Tests holds the parsed test results of test runs.
+	Tests []*TestResult `json:",omitempty"`
+	// Superseded is set for runs canceled by newer changes.
+	Superseded bool `json:",omitempty"`
+	// diags holds the diagnostics parsed from the output.
+	diags []Diag
 }
 
-func Install(pkg *Pkg) *Result {
+// Install installs pkg with the environment env.
+// Packages in modules are installed from the module root.
+// The run is killed and superseded when cancel is closed.
+func Install(pkg *Pkg, env []string, cancel <-chan struct{}) *Result {
 	r := &Result{Mode: "install"}
 	cmd := newcmd(gotool, "go", "install", pkg.Path)
+	cmd.Env = env
+	if pkg.Mod != nil {
+		cmd.Dir = pkg.Mod.Dir
+	}
 
 	err := cmd.Start()
 	if err != nil {
@@ -52,21 +67,31 @@ func Install(pkg *Pkg) *Result {
 		return r
 	}
 	r.Time = time.Now().Unix()
-	wait(cmd, r)
+	wait(cmd, r, cancel)
+	r.diags = ParseDiags(r.Stderr, cmd.Dir, SevError)
 	return r
 }
 
-func Test(pkg *Pkg) *Result {
+// Test builds and runs the tests of pkg with the environment env.
+// The run is killed and superseded when cancel is closed.
+func Test(pkg *Pkg, env []string, cancel <-chan struct{}) *Result {
 	r := &Result{Mode: "test"}
-	cmd := newcmd(gotool, "go", "test", "-c", "-i", pkg.Path)
-
 	tmp, err := ioutil.TempDir("", "labtest")
 	if err != nil {
 		r.Errmsg = err.Error()
 		return r
 	}
-	cmd.Dir = tmp
 	defer os.RemoveAll(tmp)
+	_, binary := filepath.Split(pkg.Path)
+	binary += testexe
+
+	cmd := newcmd(gotool, "go", "test", "-c", "-o", filepath.Join(tmp, binary), pkg.Path)
+	cmd.Dir = tmp
+	if pkg.Mod != nil {
+		// module mode resolves packages from the module root
+		cmd.Dir = pkg.Mod.Dir
+	}
+	cmd.Env = env
 
 	err = cmd.Start()
 	if err != nil {
@@ -74,35 +99,84 @@ func Test(pkg *Pkg) *Result {
 		return r
 	}
 	r.Time = time.Now().Unix()
-	if wait(cmd, r); r.Errmsg != "" {
+	wait(cmd, r, cancel)
+	if r.diags = ParseDiags(r.Stderr, cmd.Dir, SevError); r.Errmsg != "" {
 		return r
 	}
-	_, binary := filepath.Split(pkg.Path)
-	binary += testexe
-	cmd = newcmd(filepath.Join(tmp, binary), binary, "-test.v", "-test.short", "-test.timeout=3s")
-	cmd.Dir = pkg.Dir
-
-	err = cmd.Start()
-	if err != nil {
+	binary = filepath.Join(tmp, binary)
+	if err = runTests(pkg, binary, env, r, cancel); err != nil {
 		r.Errmsg = err.Error()
 		return r
 	}
-	wait(cmd, r)
+	r.diags = append(r.diags, testDiags(r.Tests, pkg.Dir)...)
+	if failed := failedTests(r.Tests); r.Errmsg != "" && !r.Superseded && len(failed) > 0 {
+		// run failed tests again to detect flaky tests
+		for i, name := range failed {
+			failed[i] = regexp.QuoteMeta(name)
+		}
+		rerun := &Result{}
+		if runTests(pkg, binary, env, rerun, cancel, "-test.run=^("+strings.Join(failed, "|")+")$") == nil {
+			markFlaky(r.Tests, rerun.Tests)
+		}
+		if rerun.Superseded {
+			r.Superseded, r.Errmsg = true, rerun.Errmsg
+		}
+	}
 	return r
 }
 
+// runTests runs the test binary of pkg with args through test2json and stores the
+// combined output and the test results in r.
+func runTests(pkg *Pkg, binary string, env []string, r *Result, cancel <-chan struct{}, args ...string) error {
+	args = append([]string{gotool, "go", "tool", "test2json", "-p", pkg.Path,
+		binary, "-test.v=test2json", "-test.short", "-test.timeout=3s"}, args...)
+	cmd := newcmd(args...)
+	cmd.Env = env
+	cmd.Dir = pkg.Dir
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	wait(cmd, r, cancel)
+	// keep the raw output if it is not test2json output
+	tests, out, err := ParseTestEvents(strings.NewReader(r.Stdout))
+	if err == nil {
+		r.Stdout, r.Tests = out, tests
+	}
+	return nil
+}
+
 func newcmd(args ...string) *exec.Cmd {
 	var out, err bytes.Buffer
-	return &exec.Cmd{
+	cmd := &exec.Cmd{
 		Path:   args[0],
 		Args:   args[1:],
 		Dir:    os.TempDir(),
 		Stdout: &out,
 		Stderr: &err,
+		// killed commands may leave children holding the output pipes
+		WaitDelay: 100 * time.Millisecond,
 	}
+	setpgid(cmd)
+	return cmd
 }
-func wait(cmd *exec.Cmd, r *Result) {
-	err := cmd.Wait()
+
+// wait waits for cmd to exit and stores its output in r.
+// The command and its children are killed and r is superseded when cancel is closed first.
+func wait(cmd *exec.Cmd, r *Result, cancel <-chan struct{}) {
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-cancel:
+		kill(cmd)
+		<-done
+		r.Superseded = true
+		err = fmt.Errorf("superseded")
+	}
 	if err != nil {
 		r.Errmsg = err.Error()
 	}
@@ -0,0 +1,18 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !unix
+
+package gosrc
+
+import (
+	"os/exec"
+)
+
+func setpgid(cmd *exec.Cmd) {}
+
+// kill kills the started cmd. Children of the process keep running.
+func kill(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
@@ -0,0 +1,426 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gosrc
+
+import (
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/mb0/lab/ws"
+)
+
+// FlagMod marks module root directories.
+var FlagMod uint64 = 1 << 17
+
+// modcache is the module cache directory.
+var modcache = func() string {
+	if dir := os.Getenv("GOMODCACHE"); dir != "" {
+		return dir
+	}
+	list := filepath.SplitList(build.Default.GOPATH)
+	if len(list) == 0 {
+		return ""
+	}
+	return filepath.Join(list[0], "pkg", "mod")
+}()
+
+// Version is a module path and version.
+type Version struct {
+	Path    string
+	Version string
+}
+
+// Module describes a go module read from its go.mod file.
+type Module struct {
+	// Path is the module path.
+	Path string
+	// Dir is the module root directory.
+	Dir     string
+	Require []Version
+	// Replace maps module paths and path@version pairs to their replacement.
+	// Replacements without version are directory paths.
+	Replace map[string]Version
+}
+
+// ParseMod parses the go.mod file data of the module in dir.
+// Directives other than module, require and replace are ignored.
+func ParseMod(dir string, data []byte) (*Module, error) {
+	m := &Module{Dir: dir, Replace: make(map[string]Version)}
+	var block string
+	for n, line := range strings.Split(string(data), "\n") {
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		args := strings.Fields(line)
+		if len(args) == 0 {
+			continue
+		}
+		verb := block
+		switch {
+		case block != "" && args[0] == ")":
+			block = ""
+			continue
+		case block == "":
+			verb, args = args[0], args[1:]
+			if len(args) == 1 && args[0] == "(" {
+				block = verb
+				continue
+			}
+		}
+		if err := m.directive(verb, args); err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", filepath.Join(dir, "go.mod"), n+1, err)
+		}
+	}
+	if m.Path == "" {
+		return nil, fmt.Errorf("%s: missing module path", filepath.Join(dir, "go.mod"))
+	}
+	return m, nil
+}
+
+func (m *Module) directive(verb string, args []string) error {
+	for i, arg := range args {
+		if arg[0] == '"' || arg[0] == '`' {
+			s, err := strconv.Unquote(arg)
+			if err != nil {
+				return err
+			}
+			args[i] = s
+		}
+	}
+	switch verb {
+	case "module":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: module path")
+		}
+		m.Path = args[0]
+	case "require":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: require path version")
+		}
+		m.Require = append(m.Require, Version{args[0], args[1]})
+	case "replace":
+		i := 0
+		for i < len(args) && args[i] != "=>" {
+			i++
+		}
+		n := len(args) - i - 1
+		if i < 1 || i > 2 || n < 1 || n > 2 {
+			return fmt.Errorf("usage: replace path [version] => replacement [version]")
+		}
+		old, rep := args[0], Version{Path: args[i+1]}
+		if i == 2 {
+			old += "@" + args[1]
+		}
+		if n == 2 {
+			rep.Version = args[i+2]
+		} else if !localPath(rep.Path) {
+			return fmt.Errorf("replacement module %s without version", rep.Path)
+		}
+		m.Replace[old] = rep
+	}
+	return nil
+}
+
+// Resolve returns the path and directory of the required module providing the
+// package with import path imp. Replacements are applied and versions are
+// found in the module cache.
+func (m *Module) Resolve(imp string) (path, dir string, ok bool) {
+	var req *Version
+	for i := range m.Require {
+		r := &m.Require[i]
+		if within(imp, r.Path) && (req == nil || len(r.Path) > len(req.Path)) {
+			req = r
+		}
+	}
+	if req == nil {
+		return "", "", false
+	}
+	rep, ok := m.Replace[req.Path+"@"+req.Version]
+	if !ok {
+		if rep, ok = m.Replace[req.Path]; !ok {
+			rep = *req
+		}
+	}
+	if rep.Version == "" {
+		dir = rep.Path
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(m.Dir, dir)
+		}
+		return req.Path, dir, true
+	}
+	if modcache == "" {
+		return "", "", false
+	}
+	dir = filepath.Join(modcache, escapePath(rep.Path)+"@"+escapePath(rep.Version))
+	return req.Path, dir, true
+}
+
+// within returns whether the import path imp is in the module with path.
+func within(imp, path string) bool {
+	return imp == path || strings.HasPrefix(imp, path+"/")
+}
+
+func localPath(path string) bool {
+	return filepath.IsAbs(path) || strings.HasPrefix(path, "./") || strings.HasPrefix(path, "../")
+}
+
+// escapePath returns path with upper case letters escaped as used in the module cache.
+func escapePath(path string) string {
+	var buf []byte
+	for _, c := range path {
+		if unicode.IsUpper(c) {
+			buf = append(buf, '!')
+			c = unicode.ToLower(c)
+		}
+		buf = append(buf, string(c)...)
+	}
+	return string(buf)
+}
+
+// isStd returns whether imp is a standard library import path.
+func isStd(imp string) bool {
+	if i := strings.Index(imp, "/"); i >= 0 {
+		imp = imp[:i]
+	}
+	return !strings.Contains(imp, ".")
+}
+
+// modFS is the read-only filesystem of the module cache.
+type modFS struct {
+	ws.FS
+}
+
+func (modFS) ReadOnly() bool {
+	return true
+}
+
+// isdep returns whether id is a dependency module root.
+func (s *Src) isdep(id ws.Id) bool {
+	s.modmu.Lock()
+	_, ok := s.deps[id]
+	s.modmu.Unlock()
+	return ok
+}
+
+// module returns the module with root directory r or nil if its go.mod file is invalid.
+// Mounted dependencies use the required module path.
+func (s *Src) module(r *ws.Res) *Module {
+	s.modmu.Lock()
+	m, ok := s.mods[r.Id]
+	path, dep := s.deps[r.Id]
+	s.modmu.Unlock()
+	if ok {
+		return m
+	}
+	dir := r.Path()
+	rc, err := r.FS().Open(filepath.Join(dir, "go.mod"))
+	if err == nil {
+		var data []byte
+		data, err = ioutil.ReadAll(rc)
+		rc.Close()
+		if err == nil {
+			m, err = ParseMod(dir, data)
+		}
+	}
+	if dep {
+		// dependencies without go.mod file are valid
+		if m == nil {
+			m = &Module{Dir: dir}
+		}
+		m.Path = path
+	} else if err != nil {
+		fmt.Println(err)
+	}
+	s.modmu.Lock()
+	s.mods[r.Id] = m
+	s.modmu.Unlock()
+	return m
+}
+
+// modfile handles a go.mod file event in directory r. Module roots are flagged
+// by the filter when their go.mod file is added and unflagged when it is removed.
+// The packages in the module get new import paths when worked next.
+func (s *Src) modfile(op ws.Op, r *ws.Res) {
+	s.modmu.Lock()
+	_, dep := s.deps[r.Id]
+	// read the module again when the package is worked
+	delete(s.mods, r.Id)
+	if op&ws.FsMask != 0 {
+		s.remods[r.Id] = true
+	}
+	s.modmu.Unlock()
+	if op&ws.FsMask == 0 {
+		return
+	}
+	switch op & ws.WsMask {
+	case ws.Add:
+		markGo(r, s.queue.Add)
+	case ws.Remove:
+		if !dep {
+			r.Flag &^= FlagMod
+		}
+	}
+	s.queue.Add(r)
+}
+
+// markGo flags the directories below the module root r as go source dirs and
+// calls add, if not nil, with each go source dir. The caller holds the lock of r.
+func markGo(r *ws.Res, add func(*ws.Res)) {
+	for _, c := range r.Children {
+		if c.Flag&ws.FlagDir == 0 || c.Flag&FlagMod != 0 || c.Name == "testdata" || c.Name[0] == '_' {
+			continue
+		}
+		c.Lock()
+		c.Flag |= FlagGo
+		markGo(c, add)
+		c.Unlock()
+		if add != nil {
+			add(c)
+		}
+	}
+}
+
+// repath updates the import paths and modules of the packages in modules with
+// changed go.mod files and returns their dirs to be worked. Src must be locked.
+func (s *Src) repath() []*ws.Res {
+	s.modmu.Lock()
+	roots := s.remods
+	s.remods = make(map[ws.Id]bool)
+	s.modmu.Unlock()
+	if len(roots) == 0 {
+		return nil
+	}
+	var list []*ws.Res
+	for _, p := range s.pkgs {
+		if p.Res == nil || !under(p.Res, roots) {
+			continue
+		}
+		if s.lookup[p.Path] == p {
+			delete(s.lookup, p.Path)
+		}
+		p.Path, p.Mod = s.importPath(p.Res)
+		if s.lookup[p.Path] == nil {
+			s.lookup[p.Path] = p
+		}
+		list = append(list, p.Res)
+	}
+	return list
+}
+
+// under returns whether r or one of its parents has an id in set.
+func under(r *ws.Res, set map[ws.Id]bool) bool {
+	for ; r != nil; r = r.Parent {
+		if set[r.Id] {
+			return true
+		}
+	}
+	return false
+}
+
+// require returns whether the package with import path imp is provided by the go
+// tool outside the workspace for packages in modules mods. Module dependencies
+// providing imp are mounted by mountDeps.
+func (s *Src) require(imp string, mods ...*Module) bool {
+	if isStd(imp) {
+		return true
+	}
+	for _, m := range mods {
+		if m == nil {
+			continue
+		}
+		path, dir, ok := m.Resolve(imp)
+		if !ok {
+			continue
+		}
+		s.modmu.Lock()
+		_, found := s.deps[ws.NewId(dir)]
+		s.modmu.Unlock()
+		if !found {
+			if s.pending == nil {
+				s.pending = make(map[string]string)
+			}
+			s.pending[dir] = path
+		}
+		return false
+	}
+	return false
+}
+
+// mountDeps mounts the pending module dependencies. Module cache directories
+// are mounted read-only.
+func (s *Src) mountDeps() {
+	s.Lock()
+	pending := s.pending
+	s.pending = nil
+	s.Unlock()
+	for dir, path := range pending {
+		id := ws.NewId(dir)
+		s.modmu.Lock()
+		s.deps[id] = path
+		delete(s.mods, id)
+		s.modmu.Unlock()
+		if s.ws == nil || s.ws.Res(id) != nil {
+			continue
+		}
+		var fs ws.FS = ws.Disk
+		if modcache != "" && strings.HasPrefix(dir, modcache+string(filepath.Separator)) {
+			fs = modFS{ws.Disk}
+		}
+		if _, err := s.ws.MountFS(dir, fs); err != nil {
+			fmt.Printf("error mounting module %s: %s\n", path, err)
+		}
+	}
+}
+
+// WorkDirs returns the absolute directories of the work paths.
+func (s *Src) WorkDirs() []string {
+	var dirs []string
+	for _, p := range filepath.SplitList(s.workpaths()) {
+		if d, f := filepath.Split(p); f == "..." {
+			p = d
+		}
+		if dir, err := filepath.Abs(p); err == nil {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// WorkModules returns the root directories of the modules containing the work paths
+// outside the roots. Modules in the roots are found when the roots are mounted.
+func (s *Src) WorkModules() []string {
+	var dirs []string
+	for _, dir := range s.WorkDirs() {
+		if s.inroots(dir) {
+			continue
+		}
+		for ; ; dir = filepath.Dir(dir) {
+			if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+				dirs = append(dirs, dir)
+				break
+			}
+			if dir == filepath.Dir(dir) {
+				break
+			}
+		}
+	}
+	return dirs
+}
+
+// inroots returns whether dir is in one of the roots.
+func (s *Src) inroots(dir string) bool {
+	for _, root := range s.roots {
+		if dir == root || strings.HasPrefix(dir, root+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,213 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gosrc
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mb0/lab/ws"
+)
+
+const testmod = `// test module
+module "example.com/Foo" // quoted path
+
+go 1.20
+
+require (
+	golang.org/x/text v0.3.0 // indirect
+	"example.com/bar" v1.0.0
+	example.com/bar/v2 v2.1.0
+)
+
+require github.com/Upper/Case v1.2.3
+
+replace example.com/bar v1.0.0 => ../bar
+
+replace (
+	golang.org/x/text => golang.org/x/text v0.4.0
+	example.com/old => /abs/old
+)
+`
+
+func TestParseMod(t *testing.T) {
+	m, err := ParseMod("/mod/foo", []byte(testmod))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Path != "example.com/Foo" || m.Dir != "/mod/foo" {
+		t.Errorf("expected module example.com/Foo in /mod/foo got %s in %s", m.Path, m.Dir)
+	}
+	require := []Version{
+		{"golang.org/x/text", "v0.3.0"},
+		{"example.com/bar", "v1.0.0"},
+		{"example.com/bar/v2", "v2.1.0"},
+		{"github.com/Upper/Case", "v1.2.3"},
+	}
+	if len(m.Require) != len(require) {
+		t.Fatalf("expected %d requirements got %v", len(require), m.Require)
+	}
+	for i, v := range require {
+		if m.Require[i] != v {
+			t.Errorf("expected require %v got %v", v, m.Require[i])
+		}
+	}
+	replace := map[string]Version{
+		"example.com/bar@v1.0.0": {"../bar", ""},
+		"golang.org/x/text":      {"golang.org/x/text", "v0.4.0"},
+		"example.com/old":        {"/abs/old", ""},
+	}
+	if len(m.Replace) != len(replace) {
+		t.Errorf("expected %d replacements got %v", len(replace), m.Replace)
+	}
+	for old, v := range replace {
+		if m.Replace[old] != v {
+			t.Errorf("expected replace %s => %v got %v", old, v, m.Replace[old])
+		}
+	}
+	errs := []struct {
+		data string
+		err  string
+	}{
+		{"go 1.20\n", "missing module path"},
+		{"module a b\n", ":1: usage: module path"},
+		{"module a\nrequire (\n\tb\n)\n", ":3: usage: require path version"},
+		{"module a\nreplace b => c\n", ":2: replacement module c without version"},
+		{"module a\nreplace b =>\n", ":2: usage: replace"},
+		{"module \"a\n", ":1: invalid syntax"},
+	}
+	for _, test := range errs {
+		_, err := ParseMod("/mod/a", []byte(test.data))
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("parse %q expected error %q got %v", test.data, test.err, err)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	defer func(dir string) { modcache = dir }(modcache)
+	modcache = "/cache"
+	m, err := ParseMod("/mod/foo", []byte(testmod))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		imp  string
+		path string
+		dir  string
+		ok   bool
+	}{
+		{"example.com/bar", "example.com/bar", "/mod/bar", true},
+		{"example.com/bar/sub", "example.com/bar", "/mod/bar", true},
+		{"example.com/bar/v2/sub", "example.com/bar/v2", "/cache/example.com/bar/v2@v2.1.0", true},
+		{"golang.org/x/text/unicode", "golang.org/x/text", "/cache/golang.org/x/text@v0.4.0", true},
+		{"github.com/Upper/Case", "github.com/Upper/Case", "/cache/github.com/!upper/!case@v1.2.3", true},
+		{"example.com/barx", "", "", false},
+		{"example.com/old", "", "", false},
+		{"fmt", "", "", false},
+	}
+	for _, test := range tests {
+		path, dir, ok := m.Resolve(test.imp)
+		if path != test.path || dir != test.dir || ok != test.ok {
+			t.Errorf("resolve %s expected %q %q %v got %q %q %v", test.imp,
+				test.path, test.dir, test.ok, path, dir, ok)
+		}
+	}
+}
+
+func TestEscapePath(t *testing.T) {
+	tests := []struct {
+		path   string
+		expect string
+	}{
+		{"golang.org/x/text", "golang.org/x/text"},
+		{"github.com/Upper/Case", "github.com/!upper/!case"},
+		{"v1.0.0-RC1", "v1.0.0-!r!c1"},
+	}
+	for _, test := range tests {
+		if got := escapePath(test.path); got != test.expect {
+			t.Errorf("escape %s expected %s got %s", test.path, test.expect, got)
+		}
+	}
+}
+
+func TestIsStd(t *testing.T) {
+	tests := []struct {
+		imp string
+		std bool
+	}{
+		{"fmt", true},
+		{"net/http", true},
+		{"C", true},
+		{"example.com", false},
+		{"golang.org/x/text/unicode", false},
+	}
+	for _, test := range tests {
+		if got := isStd(test.imp); got != test.std {
+			t.Errorf("isStd %s expected %v got %v", test.imp, test.std, got)
+		}
+	}
+}
+
+func TestWorkModules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gosrcmod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, path := range []string{"go.mod", "gopath/src/a/a.go", "mod/go.mod", "mod/b/b.go"} {
+		path = filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte("module x\n"), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	work := strings.Join([]string{
+		filepath.Join(dir, "gopath/src/a"),
+		filepath.Join(dir, "mod/b/..."),
+	}, string(filepath.ListSeparator))
+	s := NewRoots([]string{filepath.Join(dir, "gopath/src")}, work)
+	got := strings.Join(s.WorkModules(), " ")
+	if want := filepath.Join(dir, "mod"); got != want {
+		t.Errorf("expected work modules %q got %q", want, got)
+	}
+}
+
+func TestModRoots(t *testing.T) {
+	m := ws.NewMemFS()
+	for _, path := range []string{"/m/a/go.mod", "/m/a/b/b.go", "/m/a/testdata/t.go", "/m/c/c.go"} {
+		m.MkdirAll(filepath.Dir(path))
+		m.WriteFile(path, []byte("package x\n"))
+	}
+	s := NewRoots(nil, "")
+	w := ws.New(ws.Config{CapHint: 100, Filter: s, Handler: s})
+	defer w.Close()
+	if _, err := w.MountFS("/m", m); err != nil {
+		t.Fatal(err)
+	}
+	check := func(path string, flag uint64) {
+		r := w.Res(ws.NewId(path))
+		if r == nil {
+			t.Errorf("%s not found", path)
+		} else if got := r.Flag & (FlagGo | FlagMod); got != flag {
+			t.Errorf("%s expected flags %x got %x", path, flag, got)
+		}
+	}
+	check("/m", 0)
+	check("/m/a", FlagGo|FlagMod)
+	check("/m/a/b", FlagGo)
+	check("/m/a/testdata", 0)
+	check("/m/c", 0)
+	// the module root is detected when the go.mod file is added
+	m.WriteFile("/m/c/go.mod", []byte("module c\n"))
+	check("/m/c", FlagGo|FlagMod)
+	m.Remove("/m/c/go.mod")
+	check("/m/c", FlagGo)
+}
@@ -33,6 +33,8 @@ type Pkg struct {
 
 	// Valid
 	Path string
+	// Mod is the module containing the package or nil in GOPATH mode.
+	Mod *Module
 
 	// Scanned
 	Detail
@@ -11,10 +11,11 @@ import (
 )
 
 var (
-	failmsg = "\x1b[41mFAIL\x1b[0m "
-	failpre = "\x1b[41m    \x1b[0m "
-	okmsg   = "\x1b[42mok  \x1b[0m "
-	pending = "pending     "
+	failmsg  = "\x1b[41mFAIL\x1b[0m "
+	failpre  = "\x1b[41m    \x1b[0m "
+	okmsg    = "\x1b[42mok  \x1b[0m "
+	pending  = "pending     "
+	supermsg = "\x1b[43mSKIP\x1b[0m "
 )
 
 type Report struct {
@@ -22,10 +23,18 @@ type Report struct {
 	Dir  string
 	Path string
 	Detail
+	// Diags holds the diagnostics of the build and test results.
+	Diags []Diag `json:",omitempty"`
 }
 
 func NewReport(pkg *Pkg) *Report {
-	r := Report{pkg.Id, pkg.Dir, pkg.Path, pkg.Detail}
+	r := Report{Id: pkg.Id, Dir: pkg.Dir, Path: pkg.Path, Detail: pkg.Detail}
+	for _, res := range []*Result{pkg.Src.Result, pkg.Test.Result} {
+		// output of superseded runs is incomplete
+		if res != nil && !res.Superseded {
+			r.Diags = append(r.Diags, res.diags...)
+		}
+	}
 	r.Uses = make([]ws.Id, len(pkg.Uses))
 	copy(r.Uses, pkg.Uses)
 	r.Src.Info = pkg.Src.Info.Copy()
@@ -46,7 +55,14 @@ func (r *Report) String() string {
 			fmt.Fprintf(&buf, "%s%-7s %s", okmsg, res.Mode, r.Path)
 			continue
 		}
+		if res.Superseded {
+			fmt.Fprintf(&buf, "%s%-7s %s superseded", supermsg, res.Mode, r.Path)
+			continue
+		}
 		fmt.Fprintf(&buf, "%s%-7s %s %s", failmsg, res.Mode, r.Path, res.Errmsg)
+		if writeTests(&buf, res.Tests) {
+			continue
+		}
 		var b, l []byte
 		for _, b = range [][]byte{[]byte(res.Stdout), []byte(res.Stderr)} {
 			for b, l = line(b); len(l) > 0; b, l = line(b) {
@@ -65,6 +81,31 @@ func (r *Report) String() string {
 	return buf.String()
 }
 
+// writeTests writes the output of failed tests in list and returns whether any failed.
+func writeTests(buf *bytes.Buffer, list []*TestResult) bool {
+	var failed bool
+	for _, t := range list {
+		if t.Status != "fail" {
+			continue
+		}
+		failed = true
+		if t.Flaky {
+			fmt.Fprintf(buf, "\n%s--- FLAKY: %s passed when run again", failpre, t.Name)
+		}
+		var l []byte
+		for b := []byte(t.Output); len(b) > 0; {
+			if b, l = line(b); len(l) == 0 || bytes.HasPrefix(l, []byte("=== ")) {
+				continue
+			}
+			buf.WriteByte('\n')
+			buf.WriteString(failpre)
+			buf.Write(l)
+		}
+		writeTests(buf, t.Tests)
+	}
+	return failed
+}
+
 func line(buf []byte) ([]byte, []byte) {
 	if i := bytes.IndexByte(buf, '\n'); i > -1 {
 		return buf[i+1:], buf[:i]
@@ -0,0 +1,80 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gosrc
+
+import (
+	"sync"
+
+	"github.com/mb0/lab/ws"
+)
+
+// run is an in-flight build and test of a package.
+type run struct {
+	id ws.Id
+	// deps holds the ids of all dependencies of the package.
+	deps   map[ws.Id]bool
+	cancel chan struct{}
+	closed bool
+}
+
+// runs tracks the in-flight runs by package id. It has its own lock because
+// changes are handled while Src is locked by the running work.
+type runs struct {
+	sync.Mutex
+	all map[ws.Id]*run
+}
+
+// start registers and returns a new run of p.
+// Src must be locked to collect the dependencies of p.
+func (rs *runs) start(s *Src, p *Pkg) *run {
+	r := &run{id: p.Id, deps: make(map[ws.Id]bool), cancel: make(chan struct{})}
+	depIds(s, p, r.deps)
+	rs.Lock()
+	defer rs.Unlock()
+	if rs.all == nil {
+		rs.all = make(map[ws.Id]*run)
+	}
+	rs.all[p.Id] = r
+	return r
+}
+
+// done unregisters the run r.
+func (rs *runs) done(r *run) {
+	rs.Lock()
+	defer rs.Unlock()
+	if rs.all[r.id] == r {
+		delete(rs.all, r.id)
+	}
+}
+
+// supersede cancels the runs of the package with id and of packages depending on it.
+func (rs *runs) supersede(id ws.Id) {
+	rs.Lock()
+	defer rs.Unlock()
+	for _, r := range rs.all {
+		if (r.id == id || r.deps[id]) && !r.closed {
+			r.closed = true
+			close(r.cancel)
+		}
+	}
+}
+
+// depIds adds the ids of all packages imported by p and their dependencies to set.
+func depIds(s *Src, p *Pkg, set map[ws.Id]bool) {
+	for _, info := range []*Info{p.Src.Info, p.Test.Info} {
+		if info == nil {
+			continue
+		}
+		for _, imprt := range info.Imports {
+			if imprt.Id == 0 || set[imprt.Id] {
+				continue
+			}
+			set[imprt.Id] = true
+			if dep := s.pkgs[imprt.Id]; dep != nil {
+				depIds(s, dep, set)
+			}
+		}
+	}
+}
@@ -6,6 +6,7 @@ package gosrc
 
 import (
 	"fmt"
+	"go/ast"
 	"go/parser"
 	"go/token"
 	"path/filepath"
@@ -55,10 +56,11 @@ func getinfo(p *Pkg) (src, test *Info) {
 }
 func parse(p *Pkg, info *Info, name string) (string, error) {
 	fset := token.NewFileSet()
+	fs := p.Res.FS()
 	var lasterr error
 	for _, file := range info.Files {
 		path := filepath.Join(p.Dir, file.Name)
-		f, err := parser.ParseFile(fset, path, nil, parser.ParseComments|parser.ImportsOnly)
+		f, err := parseFile(fset, fs, path, parser.ParseComments|parser.ImportsOnly)
 		if err != nil {
 			lasterr, file.Err = err, err
 			continue
@@ -84,3 +86,13 @@ func checkname(name string, now string) (string, bool) {
 	}
 	return now, false
 }
+
+// parseFile parses the go source file at path read from fs.
+func parseFile(fset *token.FileSet, fs ws.FS, path string, mode parser.Mode) (*ast.File, error) {
+	rc, err := fs.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return parser.ParseFile(fset, path, rc, mode)
+}
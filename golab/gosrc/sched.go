@@ -0,0 +1,185 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gosrc
+
+import (
+	"runtime"
+
+	"github.com/mb0/lab"
+	"github.com/mb0/lab/ws"
+)
+
+var numjobs = lab.Conf.Int("jobs", runtime.NumCPU(), "number of concurrent install and test jobs")
+
+// installPkg and testPkg run the install and test of jobs.
+var installPkg, testPkg = Install, Test
+
+// job is a scheduled install and test of a package.
+type job struct {
+	pkg *Pkg
+	env []string
+	run *run
+	// install and test are set if the package has sources or tests.
+	install, test bool
+	// wait is the number of unfinished jobs of dependencies.
+	wait int
+	// users holds the jobs of packages importing pkg.
+	users []*job
+	// skip is set if a dependency was superseded.
+	skip      bool
+	src, tres *Result
+}
+
+// newJob returns a job for p. Src must be locked.
+func (s *Src) newJob(p *Pkg) *job {
+	j := &job{pkg: p, env: s.env, install: p.Src.Info != nil, test: p.Test.Info != nil}
+	if p.Mod != nil {
+		j.env = s.modenv
+	}
+	j.run = s.runs.start(s, p)
+	return j
+}
+
+// jobs holds the jobs of a batch by package id in work order.
+type jobs struct {
+	byid map[ws.Id]*job
+	list []*job
+}
+
+func newJobs() *jobs {
+	return &jobs{byid: make(map[ws.Id]*job)}
+}
+
+// add adds job j. A job of the same package is replaced at its position.
+func (js *jobs) add(j *job) {
+	if old := js.byid[j.pkg.Id]; old != nil {
+		for i, o := range js.list {
+			if o == old {
+				js.list[i] = j
+				break
+			}
+		}
+	} else {
+		js.list = append(js.list, j)
+	}
+	js.byid[j.pkg.Id] = j
+}
+
+// link orders jobs by the source imports of their packages. Test imports are not
+// used because external tests may import packages that depend on the tested one.
+// Src must be locked.
+func link(jobs *jobs) {
+	for _, j := range jobs.list {
+		if j.pkg.Src.Info == nil {
+			continue
+		}
+		for _, imprt := range j.pkg.Src.Info.Imports {
+			if d := jobs.byid[imprt.Id]; d != nil && d != j {
+				d.users = append(d.users, j)
+				j.wait++
+			}
+		}
+	}
+}
+
+// exec runs the install and test of the job without locking Src.
+func (j *job) exec() {
+	select {
+	case <-j.run.cancel:
+		j.skip = true
+	default:
+	}
+	if j.skip {
+		return
+	}
+	if j.install {
+		j.src = installPkg(j.pkg, j.env, j.run.cancel)
+	}
+	if !j.test {
+		return
+	}
+	if j.src != nil && j.src.Superseded {
+		j.tres = &Result{Mode: "test", Errmsg: "superseded", Superseded: true}
+	} else {
+		j.tres = testPkg(j.pkg, j.env, j.run.cancel)
+	}
+}
+
+func (j *job) superseded() bool {
+	for _, res := range []*Result{j.src, j.tres} {
+		if res != nil && res.Superseded {
+			return true
+		}
+	}
+	return j.skip
+}
+
+// build runs jobs in dependency order with at most the configured number of
+// concurrent workers. Independent jobs are started in work order. Jobs of packages
+// importing superseded ones are skipped, they are worked again with their dependency.
+func (s *Src) build(jobs *jobs) {
+	if len(jobs.list) == 0 {
+		return
+	}
+	ready := make(chan *job, len(jobs.list))
+	done := make(chan *job)
+	for _, j := range jobs.list {
+		if j.wait == 0 {
+			ready <- j
+		}
+	}
+	n := *numjobs
+	if n < 1 {
+		n = 1
+	}
+	for i := 0; i < n && i < len(jobs.list); i++ {
+		go func() {
+			for j := range ready {
+				j.exec()
+				done <- j
+			}
+		}()
+	}
+	for left := len(jobs.list); left > 0; left-- {
+		j := <-done
+		s.finish(j)
+		for _, u := range j.users {
+			if j.superseded() {
+				u.skip = true
+			}
+			if u.wait--; u.wait == 0 {
+				ready <- u
+			}
+		}
+	}
+	close(ready)
+}
+
+// finish stores the results of job j and signals its report.
+func (s *Src) finish(j *job) {
+	s.runs.done(j.run)
+	if j.skip {
+		return
+	}
+	s.Lock()
+	p := j.pkg
+	if j.src != nil {
+		p.Src.Result = j.src
+	}
+	if j.tres != nil {
+		p.Test.Result = j.tres
+	}
+	var rep *Report
+	if p.Src.Result != nil || p.Test.Result != nil {
+		rep = NewReport(p)
+	}
+	signals := s.reportsignal
+	s.Unlock()
+	if rep != nil {
+		for _, f := range signals {
+			f(rep)
+		}
+	}
+}
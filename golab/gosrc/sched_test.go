@@ -0,0 +1,132 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gosrc
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mb0/lab/ws"
+)
+
+// testJobs returns linked stub jobs for the packages in work order. Packages are
+// given as name or name:import,import.
+func testJobs(pkgs ...string) *jobs {
+	js := newJobs()
+	for _, def := range pkgs {
+		name, imports := def, ""
+		if i := strings.Index(def, ":"); i >= 0 {
+			name, imports = def[:i], def[i+1:]
+		}
+		p := &Pkg{Id: ws.NewId(name), Path: name}
+		p.Src.Info = &Info{}
+		for _, imp := range strings.Split(imports, ",") {
+			if imp != "" {
+				p.Src.Info.Imports = append(p.Src.Info.Imports, Import{imp, ws.NewId(imp)})
+			}
+		}
+		js.add(&job{pkg: p, install: true, run: &run{id: p.Id, cancel: make(chan struct{})}})
+	}
+	link(js)
+	return js
+}
+
+// stubInstall replaces the package install with f during the test.
+func stubInstall(t *testing.T, f func(*Pkg) *Result) {
+	old := installPkg
+	installPkg = func(p *Pkg, env []string, cancel <-chan struct{}) *Result {
+		return f(p)
+	}
+	t.Cleanup(func() { installPkg = old })
+}
+
+func setJobs(t *testing.T, n int) {
+	old := *numjobs
+	*numjobs = n
+	t.Cleanup(func() { *numjobs = old })
+}
+
+func TestBuildOrder(t *testing.T) {
+	setJobs(t, 1)
+	var order []string
+	stubInstall(t, func(p *Pkg) *Result {
+		order = append(order, p.Path)
+		return &Result{Mode: "install"}
+	})
+	s := NewRoots(nil, "")
+	s.build(testJobs("d:c", "c:a", "a", "b", "e:b"))
+	if got := strings.Join(order, " "); got != "a b c e d" {
+		t.Errorf("expected build order %q got %q", "a b c e d", got)
+	}
+}
+
+func TestBuildConcurrency(t *testing.T) {
+	setJobs(t, 2)
+	var (
+		mu       sync.Mutex
+		running  int
+		max      int
+		finished = make(map[string]bool)
+	)
+	js := testJobs("a", "b", "c:a", "d:a,b", "e", "f:e", "g", "h:d,f")
+	stubInstall(t, func(p *Pkg) *Result {
+		mu.Lock()
+		for _, imp := range p.Src.Info.Imports {
+			if !finished[imp.Path] {
+				t.Errorf("%s started before its dependency %s finished", p.Path, imp.Path)
+			}
+		}
+		if running++; running > max {
+			max = running
+		}
+		mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		running--
+		finished[p.Path] = true
+		mu.Unlock()
+		return &Result{Mode: "install"}
+	})
+	NewRoots(nil, "").build(js)
+	if len(finished) != len(js.list) {
+		t.Errorf("expected %d finished jobs got %d", len(js.list), len(finished))
+	}
+	if max > 2 {
+		t.Errorf("expected at most 2 concurrent jobs got %d", max)
+	}
+}
+
+func TestBuildSkip(t *testing.T) {
+	setJobs(t, 2)
+	var (
+		mu        sync.Mutex
+		installed []string
+	)
+	stubInstall(t, func(p *Pkg) *Result {
+		mu.Lock()
+		installed = append(installed, p.Path)
+		mu.Unlock()
+		if p.Path == "a" {
+			return &Result{Mode: "install", Errmsg: "superseded", Superseded: true}
+		}
+		return &Result{Mode: "install"}
+	})
+	js := testJobs("a", "b", "c:a", "d:c", "e:b")
+	NewRoots(nil, "").build(js)
+	if len(installed) != 3 {
+		t.Errorf("expected 3 installs got %v", installed)
+	}
+	for _, j := range js.list {
+		skip := j.pkg.Path == "c" || j.pkg.Path == "d"
+		if j.skip != skip {
+			t.Errorf("%s expected skip %v got %v", j.pkg.Path, skip, j.skip)
+		}
+		if skip && j.pkg.Src.Result != nil {
+			t.Errorf("%s skipped with result %v", j.pkg.Path, j.pkg.Src.Result)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gosrc
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+)
+
+// TestEvent is an event of the go tool test2json output.
+type TestEvent struct {
+	Time    time.Time
+	Action  string
+	Package string
+	Test    string
+	Elapsed float64
+	Output  string
+}
+
+// TestResult is the result of a test function or subtest.
+type TestResult struct {
+	Name string
+	// Status is pass, fail or skip, or run if the test did not finish.
+	Status string
+	// Elapsed is the test duration in seconds.
+	Elapsed float64
+	Output  string `json:",omitempty"`
+	// Flaky is set for failed tests passing when run again.
+	Flaky bool          `json:",omitempty"`
+	Tests []*TestResult `json:",omitempty"`
+}
+
+// ParseTestEvents reads the test2json events from r. It returns the top-level
+// test results with their subtests and the combined output of all events.
+func ParseTestEvents(r io.Reader) ([]*TestResult, string, error) {
+	var (
+		tests []*TestResult
+		out   bytes.Buffer
+	)
+	all := make(map[string]*TestResult)
+	dec := json.NewDecoder(r)
+	for {
+		var e TestEvent
+		if err := dec.Decode(&e); err == io.EOF {
+			break
+		} else if err != nil {
+			return tests, out.String(), err
+		}
+		out.WriteString(e.Output)
+		if e.Test == "" {
+			continue
+		}
+		t := all[e.Test]
+		if t == nil {
+			t = &TestResult{Name: e.Test, Status: "run"}
+			all[e.Test] = t
+			// subtests are named after their parent test
+			if i := strings.LastIndex(e.Test, "/"); i > 0 && all[e.Test[:i]] != nil {
+				p := all[e.Test[:i]]
+				p.Tests = append(p.Tests, t)
+			} else {
+				tests = append(tests, t)
+			}
+		}
+		switch e.Action {
+		case "output":
+			t.Output += e.Output
+		case "pass", "fail", "skip":
+			t.Status = e.Action
+			t.Elapsed = e.Elapsed
+		case "bench":
+			t.Status = "pass"
+		}
+	}
+	return tests, out.String(), nil
+}
+
+// failedTests returns the names of failed tests in list.
+func failedTests(list []*TestResult) []string {
+	var names []string
+	for _, t := range list {
+		if t.Status == "fail" {
+			names = append(names, t.Name)
+		}
+	}
+	return names
+}
+
+// markFlaky flags the failed tests in list that passed in the results of another run.
+func markFlaky(list, rerun []*TestResult) {
+	passed := make(map[string]bool, len(rerun))
+	for _, t := range rerun {
+		passed[t.Name] = t.Status == "pass"
+	}
+	for _, t := range list {
+		if t.Status == "fail" && passed[t.Name] {
+			t.Flaky = true
+		}
+	}
+}
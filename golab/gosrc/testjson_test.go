@@ -0,0 +1,93 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gosrc
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const teststream = `{"Action":"start","Package":"a"}
+{"Action":"run","Package":"a","Test":"TestPass"}
+{"Action":"output","Package":"a","Test":"TestPass","Output":"=== RUN   TestPass\n"}
+{"Action":"output","Package":"a","Test":"TestPass","Output":"--- PASS: TestPass (0.00s)\n"}
+{"Action":"pass","Package":"a","Test":"TestPass","Elapsed":0.01}
+{"Action":"run","Package":"a","Test":"TestSub"}
+{"Action":"output","Package":"a","Test":"TestSub","Output":"=== RUN   TestSub\n"}
+{"Action":"run","Package":"a","Test":"TestSub/ok"}
+{"Action":"output","Package":"a","Test":"TestSub/ok","Output":"=== RUN   TestSub/ok\n"}
+{"Action":"run","Package":"a","Test":"TestSub/bad"}
+{"Action":"output","Package":"a","Test":"TestSub/bad","Output":"=== RUN   TestSub/bad\n"}
+{"Action":"output","Package":"a","Test":"TestSub/bad","Output":"    a_test.go:12: bad value\n"}
+{"Action":"output","Package":"a","Test":"TestSub","Output":"--- FAIL: TestSub (0.00s)\n"}
+{"Action":"output","Package":"a","Test":"TestSub/ok","Output":"    --- PASS: TestSub/ok (0.00s)\n"}
+{"Action":"pass","Package":"a","Test":"TestSub/ok","Elapsed":0}
+{"Action":"output","Package":"a","Test":"TestSub/bad","Output":"    --- FAIL: TestSub/bad (0.00s)\n"}
+{"Action":"fail","Package":"a","Test":"TestSub/bad","Elapsed":0}
+{"Action":"fail","Package":"a","Test":"TestSub","Elapsed":0.02}
+{"Action":"run","Package":"a","Test":"TestSkip"}
+{"Action":"output","Package":"a","Test":"TestSkip","Output":"--- SKIP: TestSkip (0.00s)\n"}
+{"Action":"skip","Package":"a","Test":"TestSkip","Elapsed":0}
+{"Action":"run","Package":"a","Test":"TestHang"}
+{"Action":"output","Package":"a","Test":"TestHang","Output":"=== RUN   TestHang\n"}
+{"Action":"output","Package":"a","Output":"panic: test timed out after 3s\n"}
+{"Action":"fail","Package":"a","Elapsed":3.01}
+`
+
+func TestParseTestEvents(t *testing.T) {
+	tests, out, err := ParseTestEvents(strings.NewReader(teststream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out, "=== RUN   TestPass\n") || !strings.HasSuffix(out, "panic: test timed out after 3s\n") {
+		t.Errorf("unexpected output %q", out)
+	}
+	var list []string
+	var format func(prefix string, tests []*TestResult)
+	format = func(prefix string, tests []*TestResult) {
+		for _, r := range tests {
+			list = append(list, fmt.Sprintf("%s%s:%s:%g", prefix, r.Name, r.Status, r.Elapsed))
+			format(prefix+" ", r.Tests)
+		}
+	}
+	format("", tests)
+	expect := []string{
+		"TestPass:pass:0.01",
+		"TestSub:fail:0.02",
+		" TestSub/ok:pass:0",
+		" TestSub/bad:fail:0",
+		"TestSkip:skip:0",
+		"TestHang:run:0",
+	}
+	if got, want := strings.Join(list, "\n"), strings.Join(expect, "\n"); got != want {
+		t.Errorf("expected results\n%s\ngot\n%s", want, got)
+	}
+	if len(tests) == len(expect)-2 && len(tests[1].Tests) == 2 {
+		bad := tests[1].Tests[1]
+		if want := "=== RUN   TestSub/bad\n    a_test.go:12: bad value\n    --- FAIL: TestSub/bad (0.00s)\n"; bad.Output != want {
+			t.Errorf("expected subtest output %q got %q", want, bad.Output)
+		}
+	}
+	if _, _, err := ParseTestEvents(strings.NewReader(`{"Action":"run"`)); err == nil {
+		t.Error("expected error for truncated stream")
+	}
+
+	if got := strings.Join(failedTests(tests), " "); got != "TestSub" {
+		t.Errorf("expected failed tests TestSub got %q", got)
+	}
+	rerun, _, err := ParseTestEvents(strings.NewReader(`{"Action":"run","Test":"TestSub"}
+{"Action":"pass","Test":"TestSub"}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	markFlaky(tests, rerun)
+	for _, r := range tests {
+		if flaky := r.Name == "TestSub"; r.Flaky != flaky {
+			t.Errorf("%s expected flaky %v", r.Name, flaky)
+		}
+	}
+}
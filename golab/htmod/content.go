@@ -35,14 +35,14 @@ func (s *srvraw) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	path := r.URL.Path[4:]
-	res := s.ws.Res(ws.NewId(path))
+	_, res := (*htmod)(s).res(ws.NewId(path))
 	if res == nil || res.Flag&(ws.FlagDir|ws.FlagIgnore) != 0 {
 		http.NotFound(w, r)
 		return
 	}
 	switch r.Method {
 	case "GET":
-		f, err := os.Open(path)
+		f, err := res.Open()
 		if err != nil {
 			http.NotFound(w, r)
 			return
@@ -54,6 +54,10 @@ func (s *srvraw) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			log.Println(err)
 		}
 	case "POST":
+		if res.Flag&ws.FlagReadOnly != 0 {
+			http.Error(w, "read-only", http.StatusForbidden)
+			return
+		}
 		f, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0)
 		if err != nil {
 			http.NotFound(w, r)
@@ -80,14 +84,30 @@ func (s *srvdoc) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	path := r.URL.Path[5:]
-	pkg := s.src.Find(path)
+	var pkg *gosrc.Pkg
+	for _, w := range s.wss {
+		if pkg = w.src.Find(path); pkg != nil {
+			break
+		}
+	}
 	if pkg == nil {
 		http.NotFound(w, r)
 		return
 	}
 	pkg.Lock()
-	dir := pkg.Dir
+	dir, res := pkg.Dir, pkg.Res
 	pkg.Unlock()
+	if res != nil && res.Flag&ws.FlagReadOnly != 0 {
+		// read-only packages are not installed
+		raw, err := gosrc.PkgHtmlDoc(pkg)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(raw)
+		return
+	}
 	raw, err := gosrc.LoadHtmlDoc(path, false)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
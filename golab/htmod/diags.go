@@ -0,0 +1,78 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package htmod
+
+import (
+	"log"
+	"sync"
+
+	"github.com/mb0/lab/golab/gosrc"
+	"github.com/mb0/lab/hub"
+	"github.com/mb0/lab/ws"
+)
+
+// diags holds the diagnostics of the latest package reports.
+type diags struct {
+	sync.Mutex
+	// pkgs maps package ids to their diagnostics.
+	pkgs map[ws.Id][]gosrc.Diag
+}
+
+type apiDiags struct {
+	Id    ws.Id
+	Diags []gosrc.Diag
+}
+
+// file returns the diagnostics of the file with id.
+func (d *diags) file(id ws.Id) []gosrc.Diag {
+	d.Lock()
+	defer d.Unlock()
+	var list []gosrc.Diag
+	for _, pkg := range d.pkgs {
+		for _, diag := range pkg {
+			if diag.Id == id {
+				list = append(list, diag)
+			}
+		}
+	}
+	return list
+}
+
+// report updates the diagnostics of the reported package and sends the
+// diagnostics of affected files to the editors of open documents.
+func (mod *htmod) report(r *gosrc.Report) {
+	files := make(map[ws.Id]bool)
+	mod.diags.Lock()
+	for _, d := range mod.diags.pkgs[r.Id] {
+		files[d.Id] = true
+	}
+	for _, d := range r.Diags {
+		files[d.Id] = true
+	}
+	if len(r.Diags) > 0 {
+		mod.diags.pkgs[r.Id] = r.Diags
+	} else {
+		delete(mod.diags.pkgs, r.Id)
+	}
+	mod.diags.Unlock()
+	for id := range files {
+		mod.docs.RLock()
+		doc := mod.docs.all[id]
+		mod.docs.RUnlock()
+		if doc != nil {
+			mod.sendDiags(id, doc.GroupId())
+		}
+	}
+}
+
+// sendDiags sends the diagnostics of the file with id to the hub id to.
+func (mod *htmod) sendDiags(id ws.Id, to hub.Id) {
+	msg, err := hub.Marshal("diags", apiDiags{id, mod.diags.file(id)})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	mod.SendMsg(msg, to)
+}
@@ -8,11 +8,16 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/pem"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
-	"github.com/mb0/lab"
 	"github.com/mb0/lab/golab/gosrc"
 	"github.com/mb0/lab/hub"
 	"github.com/mb0/lab/ws"
@@ -32,11 +37,12 @@ var ciphers = []uint16{
 }
 
 type htmod struct {
-	conf  Config
-	roots []string
-	ws    *ws.Ws
-	src   *gosrc.Src
+	conf Config
+	// wss holds the workspaces served side by side.
+	wss   []*workspace
 	docs  *docs
+	open  *opendirs
+	diags *diags
 	*hub.Hub
 }
 
@@ -53,29 +59,39 @@ func New(conf Config) *htmod {
 }
 
 func (mod *htmod) Init() {
-	mod.roots = lab.Mod("roots").([]string)
-	mod.ws = lab.Mod("ws").(*ws.Ws)
-	mod.src = lab.Mod("gosrc").(*gosrc.Src)
+	mod.wss = loadWorkspaces()
+	mod.docs = &docs{all: make(map[ws.Id]*otdoc)}
+	mod.open = &opendirs{count: make(map[ws.Id]int)}
+	mod.diags = &diags{pkgs: make(map[ws.Id][]gosrc.Diag)}
+	for _, w := range mod.wss {
+		w.src.Prioritize(mod.priority)
+	}
 	mod.serveStatic()
 	mod.serveContent()
 }
 
 func (mod *htmod) Run() {
 	mod.Hub = hub.New()
-	mod.docs = &docs{all: make(map[ws.Id]*otdoc)}
 	go func() {
 		for e := range mod.Hub.Route {
 			mod.route(e.Msg, e.From)
 		}
 	}()
-	mod.src.SignalReports(func(r *gosrc.Report) {
-		m, err := hub.Marshal("report", r)
-		if err != nil {
-			log.Println(err)
-			return
-		}
-		mod.SendMsg(m, hub.Group)
-	})
+	for _, w := range mod.wss {
+		name := w.name
+		for _, r := range w.src.AllReports() {
+			mod.report(r)
+		}
+		w.src.SignalReports(func(r *gosrc.Report) {
+			mod.report(r)
+			m, err := hub.Marshal("report", apiReport{r, name})
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			mod.SendMsg(m, hub.Group)
+		})
+	}
 	http.Handle("/ws", mod.Hub)
 	var err error
 	server := &http.Server{
@@ -116,14 +132,45 @@ func (mod *htmod) route(m hub.Msg, id hub.Id) {
 	)
 	switch m.Head {
 	case hub.Signon:
+		names := make([]string, 0, len(mod.wss))
+		for _, w := range mod.wss {
+			names = append(names, w.name)
+		}
+		if msg, err = hub.Marshal("workspaces", names); err == nil {
+			mod.SendMsg(msg, id)
+		}
 		// send reports for all working packages
-		msg, err = hub.Marshal("reports", mod.src.AllReports())
+		msg, err = hub.Marshal("reports", mod.allReports())
 	case "stat":
 		var path string
 		if err = m.Unmarshal(&path); err != nil {
 			break
 		}
 		msg, err = mod.stat(path)
+	case "changes":
+		var req changesReq
+		if err = m.Unmarshal(&req); err != nil {
+			break
+		}
+		msg, err = mod.changes(req)
+	case "find":
+		var req findReq
+		if err = m.Unmarshal(&req); err != nil {
+			break
+		}
+		msg, err = mod.find(req)
+	case "search":
+		var req findReq
+		if err = m.Unmarshal(&req); err != nil {
+			break
+		}
+		msg, err = mod.search(req)
+	case "create", "rename", "copy", "delete":
+		var req mutateReq
+		if err = m.Unmarshal(&req); err != nil {
+			break
+		}
+		msg, err = mod.mutate(m.Head, req)
 	case "subscribe", "unsubscribe", "revise", "publish":
 		mod.docroute(m, id)
 		return
@@ -141,32 +188,38 @@ func (mod *htmod) route(m hub.Msg, id hub.Id) {
 }
 
 func (mod *htmod) stat(path string) (hub.Msg, error) {
-	res := apiRes{ws.NewId(path), path, false}
-	if r := mod.ws.Res(res.Id); r != nil {
+	res := apiRes{Id: ws.NewId(path), Name: path}
+	for _, w := range mod.wss {
+		// the sequence is read first so clients never miss later changes
+		seq := w.ws.Seq()
+		r := w.ws.Res(res.Id)
+		if r == nil {
+			continue
+		}
 		r.Lock()
 		defer r.Unlock()
-		res.Name, res.IsDir = r.Name, r.Flag&ws.FlagDir != 0
+		res = newApiRes(r)
 		if r.Dir != nil {
 			cs := make([]apiRes, 0, len(r.Children))
 			for _, c := range r.Children {
 				if c.Flag&ws.FlagIgnore == 0 {
-					cs = append(cs, apiRes{
-						c.Id,
-						c.Name,
-						c.Flag&ws.FlagDir != 0,
-					})
+					cs = append(cs, newApiRes(c))
 				}
 			}
 			return hub.Marshal("stat", struct {
 				apiRes
 				Path     string
+				Ws       string
+				Seq      uint64
 				Children []apiRes
-			}{res, path, cs})
+			}{res, path, w.name, seq, cs})
 		}
 		return hub.Marshal("stat", struct {
 			apiRes
 			Path string
-		}{res, path})
+			Ws   string
+			Seq  uint64
+		}{res, path, w.name, seq})
 	}
 	return hub.Marshal("stat.err", struct {
 		apiRes
@@ -175,8 +228,192 @@ func (mod *htmod) stat(path string) (hub.Msg, error) {
 	}{res, path, "not found"})
 }
 
+type changesReq struct {
+	Ws    string
+	Since uint64
+	Paths []string
+}
+
+// changes returns the requested folder paths with journaled changes since the
+// sequence number of the request. All paths are returned if the journal does
+// not reach back far enough or the workspace is unknown.
+func (mod *htmod) changes(req changesReq) (hub.Msg, error) {
+	var (
+		seq  uint64
+		list []ws.Entry
+		ok   bool
+	)
+	if w := mod.workspace(req.Ws); w != nil && req.Ws != "" {
+		seq = w.ws.Seq()
+		list, ok = w.ws.Since(req.Since)
+	}
+	changed := make(map[string]bool, len(list)*2)
+	for _, e := range list {
+		changed[e.Path] = true
+		changed[filepath.Dir(e.Path)] = true
+	}
+	paths := make([]string, 0, len(req.Paths))
+	for _, p := range req.Paths {
+		if !ok || changed[p] {
+			paths = append(paths, p)
+		}
+	}
+	return hub.Marshal("changes", struct {
+		Ws    string
+		Seq   uint64
+		Paths []string
+	}{req.Ws, seq, paths})
+}
+
+type findReq struct {
+	Query string
+	Max   int
+}
+
+// find returns resources matching a glob pattern if the query contains glob
+// meta characters or a slash, and fuzzy matches otherwise.
+func (mod *htmod) find(req findReq) (hub.Msg, error) {
+	if req.Max <= 0 {
+		req.Max = 50
+	}
+	var list []ws.Match
+	seen := make(map[ws.Id]bool)
+	for _, w := range mod.wss {
+		var l []ws.Match
+		if strings.ContainsAny(req.Query, "*?[/") {
+			l = w.ws.Glob(req.Query)
+		} else {
+			l = w.ws.Find(req.Query, req.Max)
+		}
+		for _, m := range l {
+			// paths mounted in multiple workspaces are listed once
+			if !seen[m.Id] {
+				seen[m.Id] = true
+				list = append(list, m)
+			}
+		}
+	}
+	if len(mod.wss) > 1 {
+		sort.Stable(byScore(list))
+	}
+	if len(list) > req.Max {
+		list = list[:req.Max]
+	}
+	return hub.Marshal("find", struct {
+		Query   string
+		Matches []ws.Match
+	}{req.Query, list})
+}
+
+type mutateReq struct {
+	Path string `json:",omitempty"`
+	Dir  bool   `json:",omitempty"`
+	From string `json:",omitempty"`
+	To   string `json:",omitempty"`
+}
+
+// mutate creates, renames, copies or deletes files and directories.
+// The request is sent back on success, errors are replied with an err message.
+func (mod *htmod) mutate(head string, req mutateReq) (hub.Msg, error) {
+	path := req.From
+	switch head {
+	case "create":
+		path = filepath.Dir(req.Path)
+	case "delete":
+		path = req.Path
+	}
+	w, _ := mod.res(ws.NewId(path))
+	err := fmt.Errorf("%s not found", path)
+	switch {
+	case w == nil:
+	case head == "create":
+		_, err = w.ws.Create(req.Path, req.Dir)
+	case head == "rename":
+		err = w.ws.Rename(req.From, req.To)
+	case head == "copy":
+		err = w.ws.Copy(req.From, req.To)
+	case head == "delete":
+		err = w.ws.Delete(req.Path)
+	}
+	if err != nil {
+		return hub.Marshal(head+".err", struct {
+			mutateReq
+			Error string
+		}{req, err.Error()})
+	}
+	return hub.Marshal(head, req)
+}
+
+// search returns lines of indexed files matching the regular expression query.
+func (mod *htmod) search(req findReq) (hub.Msg, error) {
+	if req.Max <= 0 {
+		req.Max = 100
+	}
+	var (
+		list []ws.Line
+		err  = fmt.Errorf("no search index")
+	)
+	seen := make(map[ws.Id]bool)
+	for _, w := range mod.wss {
+		if w.index == nil || len(list) >= req.Max {
+			continue
+		}
+		var l []ws.Line
+		if l, err = w.index.Search(req.Query, req.Max-len(list)); err != nil {
+			break
+		}
+		for _, line := range l {
+			// files in multiple workspaces are searched once
+			if !seen[line.Id] {
+				list = append(list, line)
+			}
+		}
+		for _, line := range l {
+			seen[line.Id] = true
+		}
+	}
+	if err != nil {
+		return hub.Marshal("search.err", struct {
+			Query string
+			Error string
+		}{req.Query, err.Error()})
+	}
+	return hub.Marshal("search", struct {
+		Query string
+		Lines []ws.Line
+	}{req.Query, list})
+}
+
 type apiRes struct {
-	Id    ws.Id
-	Name  string
-	IsDir bool
+	Id      ws.Id
+	Name    string
+	IsDir   bool
+	Size    int64
+	Mode    os.FileMode
+	ModTime time.Time
+	Link    string `json:",omitempty"`
+}
+
+func newApiRes(r *ws.Res) apiRes {
+	return apiRes{
+		Id:      r.Id,
+		Name:    r.Name,
+		IsDir:   r.Flag&ws.FlagDir != 0,
+		Size:    r.Size,
+		Mode:    r.Mode,
+		ModTime: r.ModTime,
+		Link:    r.Link,
+	}
+}
+
+type byScore []ws.Match
+
+func (l byScore) Len() int {
+	return len(l)
+}
+func (l byScore) Less(i, j int) bool {
+	return l[i].Score > l[j].Score
+}
+func (l byScore) Swap(i, j int) {
+	l[i], l[j] = l[j], l[i]
 }
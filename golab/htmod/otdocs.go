@@ -9,6 +9,7 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
 	"sync"
 
 	"github.com/mb0/diff"
@@ -24,11 +25,13 @@ type otdoc struct {
 	*ot.Server
 	ws.Id
 	Path  string
+	res   *ws.Res
+	gid   hub.Id
 	group []hub.Id
 }
 
 func (doc *otdoc) GroupId() hub.Id {
-	return hub.Id(doc.Id) | DocGroup
+	return doc.gid
 }
 func (doc *otdoc) Group() []hub.Id {
 	doc.Lock()
@@ -72,6 +75,36 @@ func (doc *otdoc) diffops(data []byte) ot.Ops {
 type docs struct {
 	sync.RWMutex
 	all map[ws.Id]*otdoc
+	// gid is the last used document group id
+	gid hub.Id
+}
+
+// opendirs counts the subscribed documents by directory id. It is locked
+// separately to look up priorities without waiting for document changes.
+type opendirs struct {
+	sync.Mutex
+	count map[ws.Id]int
+}
+
+func (d *opendirs) add(path string) {
+	d.Lock()
+	defer d.Unlock()
+	d.count[ws.NewId(filepath.Dir(path))]++
+}
+
+func (d *opendirs) del(path string) {
+	id := ws.NewId(filepath.Dir(path))
+	d.Lock()
+	defer d.Unlock()
+	if d.count[id]--; d.count[id] <= 0 {
+		delete(d.count, id)
+	}
+}
+
+func (d *opendirs) has(id ws.Id) bool {
+	d.Lock()
+	defer d.Unlock()
+	return d.count[id] > 0
 }
 
 type apiRev struct {
@@ -82,6 +115,10 @@ type apiRev struct {
 }
 
 func (mod *htmod) Handle(op ws.Op, r *ws.Res) {
+	if op&(ws.Move|ws.Add) == ws.Move|ws.Add {
+		mod.move(r)
+		return
+	}
 	if op&(ws.Modify|ws.Delete) == 0 {
 		return
 	}
@@ -106,6 +143,9 @@ func (mod *htmod) Handle(op ws.Op, r *ws.Res) {
 	if op&ws.Delete != 0 {
 		mod.Hub.Del <- doc
 		delete(mod.docs.all, doc.Id)
+		if len(doc.group) > 0 {
+			mod.open.del(doc.Path)
+		}
 		msg, err := hub.Marshal("unsubscribe", apiRev{
 			Id:   r.Id,
 			User: DocGroup,
@@ -120,7 +160,7 @@ func (mod *htmod) Handle(op ws.Op, r *ws.Res) {
 		return
 	}
 	// update from filesystem
-	data, err := ioutil.ReadFile(r.Path())
+	data, err := readRes(r)
 	if err != nil {
 		fmt.Println(err)
 		return
@@ -132,6 +172,40 @@ func (mod *htmod) Handle(op ws.Op, r *ws.Res) {
 	}
 }
 
+// move follows an open document to the new path of its moved resource.
+func (mod *htmod) move(r *ws.Res) {
+	if r.Flag&(ws.FlagIgnore|ws.FlagDir) != 0 {
+		return
+	}
+	mod.docs.Lock()
+	defer mod.docs.Unlock()
+	for id, doc := range mod.docs.all {
+		if doc.res != r {
+			continue
+		}
+		doc.Lock()
+		defer doc.Unlock()
+		delete(mod.docs.all, id)
+		if len(doc.group) > 0 {
+			mod.open.del(doc.Path)
+			mod.open.add(r.Path())
+		}
+		doc.Id, doc.Path = r.Id, r.Path()
+		mod.docs.all[doc.Id] = doc
+		msg, err := hub.Marshal("move", struct {
+			Old  ws.Id
+			Id   ws.Id
+			Path string
+		}{id, doc.Id, doc.Path})
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		mod.SendMsg(msg, doc.GroupId())
+		return
+	}
+}
+
 func (mod *htmod) docroute(m hub.Msg, from hub.Id) {
 	var rev apiRev
 	err := m.Unmarshal(&rev)
@@ -159,7 +233,7 @@ func (mod *htmod) handlerev(head string, rev apiRev, doc *otdoc) {
 			log.Println("doc not found")
 			return
 		}
-		r := mod.ws.Res(rev.Id)
+		_, r := mod.res(rev.Id)
 		if r == nil {
 			log.Println("res not found")
 			return
@@ -169,20 +243,38 @@ func (mod *htmod) handlerev(head string, rev apiRev, doc *otdoc) {
 			return
 		}
 		path := r.Path()
-		data, err := ioutil.ReadFile(path)
+		data, err := readRes(r)
 		if err != nil {
 			log.Println(err)
 			return
 		}
-		doc = &otdoc{Id: rev.Id, Path: path, Server: &ot.Server{}}
+		doc = &otdoc{Id: rev.Id, Path: path, res: r, Server: &ot.Server{}}
+		mod.docs.gid++
+		doc.gid = mod.docs.gid | DocGroup
 		doc.Lock()
 		defer doc.Unlock()
 		doc.Doc = (*ot.Doc)(&data)
 		mod.docs.all[doc.Id] = doc
 		mod.Hub.Add <- doc
 	}
+	if doc.res.Flag&ws.FlagReadOnly != 0 && (head == "revise" || head == "publish") {
+		// documents of read-only mounts are never edited
+		m, err = hub.Marshal(head+".err", struct {
+			apiRev
+			Error string
+		}{rev, "read-only"})
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		mod.SendMsg(m, rev.User)
+		return
+	}
 	switch head {
 	case "subscribe":
+		if len(doc.group) == 0 {
+			mod.open.add(doc.Path)
+		}
 		doc.group = append(doc.group, rev.User)
 		m, err = hub.Marshal("subscribe", apiRev{
 			Id:   rev.Id,
@@ -198,6 +290,7 @@ func (mod *htmod) handlerev(head string, rev apiRev, doc *otdoc) {
 			doc.group = append(doc.group[:i], doc.group[i+1:]...)
 			if len(doc.group) == 0 {
 				mod.Hub.Del <- doc
+				mod.open.del(doc.Path)
 			}
 			break
 		}
@@ -253,4 +346,25 @@ func (mod *htmod) handlerev(head string, rev apiRev, doc *otdoc) {
 	if to != 0 {
 		mod.SendMsg(m, to)
 	}
+	if head == "subscribe" {
+		mod.sendDiags(rev.Id, rev.User)
+	}
+}
+
+// priority returns a positive class for directories containing open documents.
+func (mod *htmod) priority(r *ws.Res) int {
+	if mod.open.has(r.Id) {
+		return 1
+	}
+	return 0
+}
+
+// readRes reads the content of the file resource r from its filesystem.
+func readRes(r *ws.Res) ([]byte, error) {
+	f, err := r.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(f)
 }
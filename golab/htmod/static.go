@@ -5,6 +5,7 @@
 package htmod
 
 import (
+	"go/build"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -29,7 +30,12 @@ func (mod *htmod) serveStatic() {
 }
 
 func (mod *htmod) findsrc(path string) string {
-	for _, dir := range mod.roots {
+	var dirs []string
+	for _, w := range mod.wss {
+		dirs = append(dirs, w.roots...)
+	}
+	dirs = append(dirs, build.Default.SrcDirs()...)
+	for _, dir := range dirs {
 		p := filepath.Join(dir, path)
 		if _, err := os.Stat(p); err == nil {
 			return p
@@ -0,0 +1,74 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package htmod
+
+import (
+	"github.com/mb0/lab"
+	"github.com/mb0/lab/golab/gosrc"
+	"github.com/mb0/lab/ws"
+)
+
+// workspace holds the modules of a named workspace.
+type workspace struct {
+	name  string
+	roots []string
+	ws    *ws.Ws
+	index *ws.Index
+	src   *gosrc.Src
+}
+
+// loadWorkspaces returns the workspaces registered by name in the lab.
+func loadWorkspaces() []*workspace {
+	names, _ := lab.Mod("workspaces").([]string)
+	list := make([]*workspace, 0, len(names))
+	for _, name := range names {
+		suffix := "/" + name
+		w := &workspace{
+			name: name,
+			ws:   lab.Mod("ws" + suffix).(*ws.Ws),
+			src:  lab.Mod("gosrc" + suffix).(*gosrc.Src),
+		}
+		w.roots, _ = lab.Mod("roots" + suffix).([]string)
+		w.index, _ = lab.Mod("index" + suffix).(*ws.Index)
+		list = append(list, w)
+	}
+	return list
+}
+
+// workspace returns the workspace with name or the first workspace if name is empty.
+func (mod *htmod) workspace(name string) *workspace {
+	for _, w := range mod.wss {
+		if name == "" || w.name == name {
+			return w
+		}
+	}
+	return nil
+}
+
+// res returns the first workspace containing the resource with id and the resource.
+func (mod *htmod) res(id ws.Id) (*workspace, *ws.Res) {
+	for _, w := range mod.wss {
+		if r := w.ws.Res(id); r != nil {
+			return w, r
+		}
+	}
+	return nil, nil
+}
+
+// apiReport is a package report tagged with the workspace name.
+type apiReport struct {
+	*gosrc.Report
+	Ws string
+}
+
+func (mod *htmod) allReports() []apiReport {
+	var list []apiReport
+	for _, w := range mod.wss {
+		for _, r := range w.src.AllReports() {
+			list = append(list, apiReport{r, w.name})
+		}
+	}
+	return list
+}
@@ -22,8 +22,8 @@ var (
 	cacertFile = lab.Conf.String("cacert", "", "client ca cert file for authentication")
 )
 
-func init() {
-	lab.LoadConf()
+// startHttp registers the http module if configured. The config must be loaded.
+func startHttp() {
 	if !(*useHttp || *useHttps) {
 		return
 	}
@@ -14,43 +14,137 @@ import (
 	"go/build"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/mb0/lab"
 	"github.com/mb0/lab/golab/gosrc"
 	"github.com/mb0/lab/ws"
 )
 
+var (
+	pollpaths = lab.Conf.String("poll", "", "path list of mounts to poll instead of watch")
+	linkmode  = lab.Conf.String("links", "follow", "symbolic link policy: follow, mark or ignore")
+	ignores   = lab.Conf.String("ignore", "", "comma separated gitignore patterns for all roots")
+	coalesce  = lab.Conf.Duration("coalesce", 50*time.Millisecond, "window to fold event bursts per file, 0 to disable")
+	cachefile = lab.Conf.String("cache", "~/.golab/ws.cache", "workspace snapshot cache file, empty to disable")
+	readonly  = lab.Conf.String("readonly", "", "comma separated read-only mounts path=archive or path=repo@rev")
+)
+
+// golab manages one named workspace with its own roots, filters and go sources.
 type golab struct {
+	name     string
 	roots    []string
+	readonly string
+	cache    string
 	ws       *ws.Ws
+	src      *gosrc.Src
+	index    *ws.Index
+	ignore   *ws.Ignore
 	filters  []ws.Filter
 	handlers []ws.Handler
 }
 
 func main() {
 	lab.LoadConf()
-	roots := build.Default.SrcDirs()
-	lab.Register("roots", roots)
-	lab.Register("gosrc", gosrc.New())
-	golab := &golab{roots: roots}
-	golab.ws = ws.New(ws.Config{
-		CapHint: 8000,
-		Watcher: ws.NewInotify,
-		Filter:  golab,
-		Handler: golab,
-	})
-	defer golab.ws.Close()
-	lab.Register("ws", golab.ws)
-	lab.Register("golab", golab)
+	startHttp()
+	if len(workspaces) == 0 {
+		workspaces.Set("default")
+	}
+	links := ws.LinkFollow
+	switch *linkmode {
+	case "mark":
+		links = ws.LinkMark
+	case "ignore":
+		links = ws.LinkIgnore
+	}
+	var names []string
+	var labs []*golab
+	for i, def := range workspaces {
+		golab := newGolab(def, i == 0)
+		golab.ws = ws.New(ws.Config{
+			CapHint:  8000,
+			Watcher:  ws.NewInotify,
+			Filter:   golab,
+			Handler:  golab,
+			Poll:     golab.Poll,
+			Links:    links,
+			Coalesce: *coalesce,
+			Snapshot: readCache(golab.cache),
+		})
+		golab.src.SetWs(golab.ws)
+		defer golab.ws.Close()
+		defer golab.index.Close()
+		names = append(names, def.name)
+		labs = append(labs, golab)
+	}
+	lab.Register("workspaces", names)
+	for _, golab := range labs {
+		suffix := "/" + golab.name
+		lab.Register("roots"+suffix, golab.roots)
+		lab.Register("gosrc"+suffix, golab.src)
+		lab.Register("index"+suffix, golab.index)
+		lab.Register("ws"+suffix, golab.ws)
+		lab.Register("golab"+suffix, golab)
+	}
 	lab.Start()
 	c := make(chan os.Signal)
 	signal.Notify(c, os.Interrupt, os.Kill)
 	<-c
+	for _, golab := range labs {
+		golab.writeCache()
+	}
+}
+
+// newGolab returns a workspace for def. The first workspace defaults to the go
+// source dirs and the global ignore, read-only, cache and work flags. Other
+// workspaces work on all packages in their roots by default.
+func newGolab(def wsdef, first bool) *golab {
+	l := &golab{name: def.name, readonly: def.readonly}
+	if def.roots != "" {
+		for _, root := range filepath.SplitList(def.roots) {
+			if root, err := lab.ExpandHome(root); err == nil {
+				l.roots = append(l.roots, root)
+			}
+		}
+	} else {
+		l.roots = build.Default.SrcDirs()
+	}
+	ignore, work := def.ignore, def.work
+	if first {
+		if ignore == "" {
+			ignore = *ignores
+		}
+		if l.readonly == "" {
+			l.readonly = *readonly
+		}
+		l.cache = *cachefile
+	} else {
+		if *cachefile != "" {
+			l.cache = *cachefile + "." + def.name
+		}
+		if work == "" {
+			paths := make([]string, 0, len(l.roots))
+			for _, root := range l.roots {
+				paths = append(paths, filepath.Join(root, "..."))
+			}
+			work = strings.Join(paths, string(filepath.ListSeparator))
+		}
+	}
+	l.ignore = ws.NewIgnore(".gitignore", strings.Split(ignore, ","))
+	l.src = gosrc.NewRoots(l.roots, work)
+	l.index = ws.NewIndex()
+	// only the work paths are searched
+	l.index.Scope(l.src.WorkDirs()...)
+	return l
 }
 
 func (l *golab) Init() {
+	// modules of other workspaces are skipped
 	for _, mod := range lab.All() {
-		if _, ok := mod.(*golab); ok {
+		switch mod.(type) {
+		case *golab, *gosrc.Src, *ws.Index:
 			continue
 		}
 		if f, ok := mod.(ws.Filter); ok {
@@ -60,12 +154,124 @@ func (l *golab) Init() {
 			l.handlers = append(l.handlers, h)
 		}
 	}
-	fmt.Println("starting lab for:", l.roots)
+	l.filters = append(l.filters, l.src)
+	l.handlers = append(l.handlers, l.src, l.index)
+	fmt.Printf("starting lab %s for: %s\n", l.name, l.roots)
 	for i, err := range ws.MountAll(l.ws, l.roots) {
 		if err != nil {
 			fmt.Printf("error mounting %s: %s\n", l.roots[i], err)
 		}
 	}
+	// mount modules of work paths outside the roots
+	mounts := append([]string(nil), l.roots...)
+	for _, dir := range l.src.WorkModules() {
+		if contains(mounts, dir) {
+			continue
+		}
+		if _, err := l.ws.Mount(dir); err != nil {
+			fmt.Printf("error mounting %s: %s\n", dir, err)
+		}
+		mounts = append(mounts, dir)
+	}
+	for _, m := range strings.Split(l.readonly, ",") {
+		if m == "" {
+			continue
+		}
+		if err := l.mountReadOnly(m); err != nil {
+			fmt.Printf("error mounting %s: %s\n", m, err)
+		}
+	}
+	l.writeCache()
+}
+
+// mountReadOnly mounts the zip or tar archive or git revision described by m.
+// Git revisions are separated from the repository path by the last '@'.
+func (l *golab) mountReadOnly(m string) error {
+	i := strings.Index(m, "=")
+	if i < 0 {
+		return fmt.Errorf("expected path=source")
+	}
+	path, err := filepath.Abs(m[:i])
+	if err != nil {
+		return err
+	}
+	src, rev := m[i+1:], ""
+	if i = strings.LastIndex(src, "@"); i >= 0 {
+		src, rev = src[:i], src[i+1:]
+	}
+	if src, err = lab.ExpandHome(src); err != nil {
+		return err
+	}
+	var fs ws.FS
+	if rev != "" {
+		fs, err = ws.NewGitFS(path, src, rev)
+	} else {
+		fs, err = ws.NewArchiveFS(path, src)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = l.ws.MountFS(path, fs)
+	return err
+}
+
+// contains returns whether path is in one of the directories dirs.
+func contains(dirs []string, path string) bool {
+	for _, dir := range dirs {
+		if path == dir || strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+func readCache(file string) *ws.Snapshot {
+	path, err := lab.ExpandHome(file)
+	if err != nil || path == "" {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	snap, err := ws.ReadSnapshot(f)
+	if err != nil {
+		fmt.Println("error reading cache:", err)
+		return nil
+	}
+	return snap
+}
+
+func (l *golab) writeCache() {
+	path, err := lab.ExpandHome(l.cache)
+	if err != nil || path == "" {
+		return
+	}
+	os.MkdirAll(filepath.Dir(path), 0755)
+	// write to a temporary file so readers never see partial snapshots
+	f, err := os.Create(path + ".tmp")
+	if err == nil {
+		err = l.ws.WriteSnapshot(f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	if err != nil {
+		fmt.Println("error writing cache:", err)
+	}
+}
+
+func (l *golab) Poll(path string) bool {
+	for _, p := range filepath.SplitList(*pollpaths) {
+		if p, err := filepath.Abs(p); err == nil && p == path {
+			return true
+		}
+	}
+	return false
 }
 
 func (l *golab) Filter(r *ws.Res) bool {
@@ -85,6 +291,9 @@ func (l *golab) Filter(r *ws.Res) bool {
 			return true
 		}
 	}
+	if l.ignore.Filter(r) {
+		return true
+	}
 	for _, f := range l.filters {
 		if f.Filter(r) {
 			return true
@@ -94,6 +303,8 @@ func (l *golab) Filter(r *ws.Res) bool {
 }
 
 func (l *golab) Handle(op ws.Op, r *ws.Res) {
+	// pattern files are usually ignored dotfiles
+	l.ignore.Handle(op, r)
 	if r.Flag&ws.FlagIgnore != 0 {
 		return
 	}
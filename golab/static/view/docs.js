@@ -67,6 +67,8 @@ var DocsView = Backbone.View.extend({
 		this.listenTo(conn, "msg:revise.err", this.panic);
 		this.listenTo(conn, "msg:publish", this.onPublish);
 		this.listenTo(conn, "msg:unsubscribe", this.onUnsubscribe);
+		this.listenTo(conn, "msg:move", this.onMove);
+		this.listenTo(conn, "msg:diags", this.onDiags);
 		this.render();
 	},
 	panic: function(data) {
@@ -114,6 +116,19 @@ var DocsView = Backbone.View.extend({
 			this.panic({Err: err});
 		}
 	},
+	onMove: function(data) {
+		var doc = this.collection.get(data.Old);
+		if (!doc) {
+			console.log("move unknown document", data);
+			return;
+		}
+		doc.set({Id: data.Id, Path: data.Path});
+	},
+	onDiags: function(data) {
+		var doc = this.collection.get(data.Id);
+		if (!doc) return;
+		doc.set("Diags", data.Diags || []);
+	},
 	onUnsubscribe: function(data) {
 		var doc = this.collection.get(data.Id);
 		if (!doc) {
@@ -3,8 +3,8 @@ Copyright 2013 Martin Schnabel. All rights reserved.
 Use of this source code is governed by a BSD-style
 license that can be found in the LICENSE file.
 */
-define(["conn", "view/modes", "view/ace", "view/docs", "lib/paths", "lib/completion"],
-function(conn, modes, ace, docs, paths, completion) {
+define(["conn", "view/modes", "view/ace", "view/docs", "lib/paths", "lib/completion", "ace/range"],
+function(conn, modes, ace, docs, paths, completion, range) {
 
 function getCommands(doc) {
 	var list = [{
@@ -59,8 +59,10 @@ var EditorView = Backbone.View.extend({
 		this.$el.html(this.template(this.model));
 		this.$editor = $('<div class="content">').appendTo(this.$el);
 		this.editor = null;
+		this.markers = [];
 		this.doc = docs.getOrCreate(this.model.id, this.model.getPath());
 		this.listenTo(conn, "msg:complete", this.onMsgComplete);
+		this.listenTo(this.doc, "change:Diags", this.showDiags);
 		this.listenTo(this.model, "remove", this.remove);
 		var tis = this;
 		this.doc.subscribe(function() {
@@ -80,6 +82,8 @@ var EditorView = Backbone.View.extend({
 		if (this.line > 0) {
 			this.setLine(this.line);
 		}
+		this.showDiags();
+		session.on("change", _.debounce(_.bind(this.annotate, this), 100));
 		if (mode.id !== "golang") {
 			return;
 		}
@@ -97,6 +101,40 @@ var EditorView = Backbone.View.extend({
 			Backbone.history.navigate("doc/"+ path, {trigger: true});
 		});
 	},
+	showDiags: function() {
+		if (this.editor === null) return;
+		var session = this.editor.getSession();
+		this.clearDiags();
+		// anchored ranges follow the text as it is edited
+		this.markers = _.map(this.doc.get("Diags"), function(d) {
+			var r = new range.Range(d.Line-1, 0, d.Line-1, Infinity);
+			r.start = session.getDocument().createAnchor(r.start);
+			r.end = session.getDocument().createAnchor(r.end);
+			var id = session.addMarker(r, "diag diag-"+ d.Severity, "fullLine");
+			return {id: id, range: r, diag: d};
+		});
+		this.annotate();
+	},
+	clearDiags: function() {
+		var session = this.editor.getSession();
+		_.each(this.markers, function(m) {
+			session.removeMarker(m.id);
+			m.range.start.detach();
+			m.range.end.detach();
+		});
+		this.markers = [];
+	},
+	annotate: function() {
+		if (this.editor === null) return;
+		this.editor.getSession().setAnnotations(_.map(this.markers, function(m) {
+			return {
+				row: m.range.start.row,
+				column: m.range.start.column,
+				text: m.diag.Msg,
+				type: m.diag.Severity,
+			};
+		}));
+	},
 	onMsgComplete: function(data) {
 		if (data.Id !== this.model.id) return;
 		completion.show(this.editor, data);
@@ -111,6 +149,7 @@ var EditorView = Backbone.View.extend({
 		}
 	},
 	remove: function() {
+		this.clearDiags();
 		this.editor.destroy();
 		this.editor = null;
 		this.$editor = null;
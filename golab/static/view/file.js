@@ -30,6 +30,11 @@ var FileView = Backbone.View.extend({
 	},
 	onstat: function(data) {
 		if (data.Path != this.path) return;
+		if (this.content && data.Children && this.content.refresh) {
+			// refreshed folder
+			this.content.refresh(data);
+			return;
+		}
 		var opts = {el: this.el, model: data, tile: this.tile};
 		if (data.Error)  {
 			alert(data.Error);
@@ -3,8 +3,8 @@ Copyright 2013 Martin Schnabel. All rights reserved.
 Use of this source code is governed by a BSD-style
 license that can be found in the LICENSE file.
 */
-define(["base", "lib/paths", "backbone"],
-function(base, paths) {
+define(["base", "conn", "lib/paths", "backbone"],
+function(base, conn, paths) {
 
 var File = Backbone.Model.extend({
 	idAttribute: "Id",
@@ -68,8 +68,26 @@ var FolderView = Backbone.View.extend({
 		this.listenTo(this.model, "change", this.render);
 		this.listenTo(this.model, "remove", this.remove);
 		this.listenTo(this.tile, "remove", this.remove);
+		// ask for changes missed while disconnected
+		this.listenTo(conn, "open", this.onopen);
+		this.listenTo(conn, "msg:changes", this.onchanges);
 		this.render();
 	},
+	onopen: function() {
+		conn.send("changes", {
+			Ws: this.model.get("Ws"),
+			Since: this.model.get("Seq"),
+			Paths: [this.model.getPath()],
+		});
+	},
+	onchanges: function(data) {
+		if (_.contains(data.Paths, this.model.getPath())) {
+			conn.send("stat", this.model.getPath());
+		}
+	},
+	refresh: function(data) {
+		this.model.set(data);
+	},
 	render: function() {
 		this.$("header").replaceWith(this.template(this.model));
 		this.children.reset(_.map(this.model.get("Children"), function(c) {
@@ -5,6 +5,12 @@ license that can be found in the LICENSE file.
 */
 define(["base", "conn", "tile"], function(base, conn, tile) {
 
+// workspaces holds the names of the workspaces served side by side.
+var workspaces = [];
+conn.on("msg:workspaces", function(data) {
+	workspaces = data || [];
+});
+
 var Report = Backbone.Model.extend({
 	idAttribute: "Id",
 	getresult: function() {
@@ -15,7 +21,7 @@ var Report = Backbone.Model.extend({
 	},
 	haserrors: function(res) {
 		res = res || this.getresult();
-		return res && res.Errmsg != null;
+		return res && res.Errmsg != null && !res.Superseded;
 	},
 	getoutput: function(res) {
 		if (!res) return "";
@@ -26,6 +32,22 @@ var Report = Backbone.Model.extend({
 		out = out.replace(/\n(([\w_]+\.go)\:(\d+)(?:\:\d+)?\:)/g, '\n<a href="#file' + this.get('Dir') + '/$2#L$3">$1</a>');
 		return out.replace(/(^(#.*|\S)\n|\n#[^\n]*)/g, "");
 	},
+	gettests: function(res) {
+		// flatten subtests with their depth
+		var list = [];
+		var add = function(tests, depth) {
+			_.each(tests, function(t) {
+				list.push({test: t, depth: depth});
+				add(t.Tests, depth+1);
+			});
+		};
+		add(res && res.Tests, 0);
+		return list;
+	},
+	getws: function() {
+		// the workspace is only shown if there are more than one
+		return workspaces.length > 1 ? this.get("Ws") : "";
+	},
 	getfiles: function() {
 		var res, files = [];
 		if ((res = this.get("Src")) && res.Info)
@@ -38,30 +60,46 @@ var Report = Backbone.Model.extend({
 
 var Reports = Backbone.Collection.extend({model:Report});
 
+
 var ReportListItem = base.ListItemView.extend({
 	events: {
 		"click .status": "toggleReport",
+		"click .tests .name": "toggleTest",
 	},
 	template: _.template([
 		'<% var res = getresult(); var err = haserrors(res), o = getoutput(res) %>',
-		'<div class="report <%- err ? "fail" : "ok" %>">',
+		'<% var skip = res && res.Superseded %>',
+		'<div class="report <%- skip ? "superseded" : err ? "fail" : "ok" %>">',
 		'<header>',
 		'<span class="status">',
-		'<%- err ? "FAIL" : "OK" %>',
-		'<% if (o) { %><i class="icon icon-plus"></i><% } %>',
+		'<%- skip ? "SKIP" : err ? "FAIL" : "OK" %>',
+		'<% var tests = gettests(res) %>',
+		'<% if (o || tests.length) { %><i class="icon icon-plus"></i><% } %>',
 		'</span> ',
 		'<span class="mode"><%= res && res.Mode || "" %></span> ',
+		'<% var ws = getws(); if (ws) { %><span class="ws"><%- ws %></span> <% } %>',
 		'<a href="#file<%= get("Dir") %>"><%= get("Path") %></a> <%= res && res.Errmsg || "" %>',
 		'</header>',
-		'<% if (o) { %><pre <%- err ? "" : \'style="display:none"\'',
+		'<% if (tests.length) { %><ul class="tests" <%- err ? "" : \'style="display:none"\' %>>',
+		'<% _.each(tests, function(t) { var test = t.test %>',
+		'<li class="<%- test.Status %>" style="padding-left:<%- t.depth %>em">',
+		'<span class="name"><%- test.Name %></span> ',
+		'<span class="elapsed"><%- test.Elapsed.toFixed(2) %>s</span>',
+		'<% if (test.Flaky) { %> <span class="flaky">flaky</span><% } %>',
+		'<% if (test.Output) { %><pre><%= fixoutput(test.Output) %></pre><% } %>',
+		'</li><% }) %></ul><% } %>',
+		'<% if (o && !tests.length) { %><pre <%- err ? "" : \'style="display:none"\'',
 		'%>><%= fixoutput(o) %></pre><% } %>',
 		'</div>',
 	].join('')),
 	toggleReport: function(e) {
-		this.$(".report pre").toggle();
+		this.$(".report > pre, .report > .tests").toggle();
 		this.$(".report i")
 			.toggleClass("icon-plus")
 			.toggleClass("icon-minus");
+	},
+	toggleTest: function(e) {
+		$(e.currentTarget).siblings("pre").toggle();
 	}
 });
 
@@ -0,0 +1,74 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mb0/lab"
+)
+
+var workspaces wsdefs
+
+func init() {
+	lab.Conf.Var(&workspaces, "workspace", "named workspace `name;roots=paths;work=paths;ignore=patterns;readonly=mounts`, may be repeated")
+}
+
+// wsdef defines a named workspace. Empty fields use the defaults.
+type wsdef struct {
+	name     string
+	roots    string
+	work     string
+	ignore   string
+	readonly string
+}
+
+// wsdefs implements flag.Value for repeated workspace definitions.
+// Definitions replace earlier definitions with the same name, because the
+// config flags are parsed more than once.
+type wsdefs []wsdef
+
+func (l *wsdefs) String() string {
+	names := make([]string, 0, len(*l))
+	for _, d := range *l {
+		names = append(names, d.name)
+	}
+	return strings.Join(names, ",")
+}
+
+func (l *wsdefs) Set(s string) error {
+	parts := strings.Split(s, ";")
+	d := wsdef{name: parts[0]}
+	if d.name == "" || strings.ContainsAny(d.name, "/=") {
+		return fmt.Errorf("invalid workspace name %q", d.name)
+	}
+	for _, part := range parts[1:] {
+		i := strings.Index(part, "=")
+		if i < 0 {
+			return fmt.Errorf("workspace %s: expected key=value got %q", d.name, part)
+		}
+		switch key, val := part[:i], part[i+1:]; key {
+		case "roots":
+			d.roots = val
+		case "work":
+			d.work = val
+		case "ignore":
+			d.ignore = val
+		case "readonly":
+			d.readonly = val
+		default:
+			return fmt.Errorf("workspace %s: unknown key %s", d.name, key)
+		}
+	}
+	for i, o := range *l {
+		if o.name == d.name {
+			(*l)[i] = d
+			return nil
+		}
+	}
+	*l = append(*l, d)
+	return nil
+}
@@ -0,0 +1,56 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWsdefsSet(t *testing.T) {
+	var l wsdefs
+	if err := l.Set("a;roots=/src:/other;work=./...;ignore=*.o,tmp;readonly=/src"); err != nil {
+		t.Fatal(err)
+	}
+	want := wsdef{"a", "/src:/other", "./...", "*.o,tmp", "/src"}
+	if len(l) != 1 || l[0] != want {
+		t.Fatalf("expected %v got %v", want, l)
+	}
+	if err := l.Set("b;work=x=y"); err != nil {
+		t.Fatal(err)
+	}
+	if len(l) != 2 || l[1] != (wsdef{name: "b", work: "x=y"}) {
+		t.Errorf("expected value with equal sign got %v", l)
+	}
+	errs := []struct {
+		def string
+		err string
+	}{
+		{"", "invalid workspace name"},
+		{";roots=/src", "invalid workspace name"},
+		{"a/b", "invalid workspace name"},
+		{"a=b", "invalid workspace name"},
+		{"c;roots", "expected key=value"},
+		{"c;color=red", "unknown key color"},
+	}
+	for _, test := range errs {
+		if err := l.Set(test.def); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("set %q expected error %q got %v", test.def, test.err, err)
+		}
+	}
+	if len(l) != 2 {
+		t.Errorf("invalid definitions added %v", l)
+	}
+	// the config flags are parsed again
+	if err := l.Set("a;roots=/new"); err != nil {
+		t.Fatal(err)
+	}
+	if len(l) != 2 || l[0] != (wsdef{name: "a", roots: "/new"}) {
+		t.Errorf("expected replaced definition got %v", l)
+	}
+	if got := l.String(); got != "a,b" {
+		t.Errorf("expected names a,b got %q", got)
+	}
+}
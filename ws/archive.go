@@ -0,0 +1,110 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ws
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// NewArchiveFS returns a read-only filesystem with the contents of the zip or tar
+// archive at file to be mounted at path. Tar archives may be compressed with gzip.
+// The contents are read into memory.
+func NewArchiveFS(path, file string) (FS, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	t := newTreeFS(path, fi.ModTime())
+	switch name := strings.ToLower(file); {
+	case strings.HasSuffix(name, ".zip"):
+		err = t.readZip(f, fi.Size())
+	case strings.HasSuffix(name, ".tar"):
+		err = t.readTar(f)
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		var gz *gzip.Reader
+		if gz, err = gzip.NewReader(f); err == nil {
+			err = t.readTar(gz)
+		}
+	default:
+		err = fmt.Errorf("unknown archive format %s", file)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *treeFS) readZip(r io.ReaderAt, size int64) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+	for _, zf := range zr.File {
+		fi := zf.FileInfo()
+		f := &treefile{mode: fi.Mode(), mod: fi.ModTime()}
+		if !fi.IsDir() {
+			rc, err := zf.Open()
+			if err != nil {
+				return err
+			}
+			f.data, err = ioutil.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+			if fi.Mode()&os.ModeSymlink != 0 {
+				f.link, f.data = string(f.data), nil
+			}
+		}
+		t.add(zf.Name, f)
+	}
+	return nil
+}
+
+func (t *treeFS) readTar(r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		f := &treefile{mode: os.FileMode(h.Mode).Perm(), mod: h.ModTime}
+		switch h.Typeflag {
+		case tar.TypeDir:
+			f.mode |= os.ModeDir
+		case tar.TypeSymlink:
+			f.mode |= os.ModeSymlink
+			f.link = h.Linkname
+		case tar.TypeReg:
+			if f.data, err = ioutil.ReadAll(tr); err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			// hard links share the content of previous files
+			if l, ok := t.files[filepath.Join(t.root, filepath.FromSlash(h.Linkname))]; ok && !l.IsDir() {
+				f.data, f.size = l.data, l.size
+			}
+		default:
+			continue
+		}
+		t.add(h.Name, f)
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ws
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+var archiveFiles = []struct {
+	name, data string
+}{
+	{"pkg/a.go", "package pkg\n"},
+	{"pkg/sub/b.go", "package sub\n"},
+	{"README", "readme\n"},
+}
+
+func checkTree(t *testing.T, msg string, fs FS) {
+	w := New(Config{CapHint: 100})
+	defer w.Close()
+	r, err := w.MountFS("/mnt/rel", fs)
+	if err != nil {
+		t.Fatalf("%s: %s", msg, err)
+	}
+	if r.Flag&FlagReadOnly == 0 {
+		t.Errorf("%s: mount not read-only", msg)
+	}
+	for _, f := range archiveFiles {
+		r := w.Res(NewId("/mnt/rel/" + f.name))
+		if r == nil {
+			t.Errorf("%s: %s not found", msg, f.name)
+			continue
+		}
+		if r.Flag&FlagReadOnly == 0 {
+			t.Errorf("%s: %s not read-only", msg, f.name)
+		}
+		rc, err := r.Open()
+		if err != nil {
+			t.Errorf("%s: %s", msg, err)
+			continue
+		}
+		data, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil || string(data) != f.data || r.Size != int64(len(f.data)) {
+			t.Errorf("%s: %s read %q size %d %v", msg, f.name, data, r.Size, err)
+		}
+	}
+	if _, err := w.Create("/mnt/rel/new", false); err == nil {
+		t.Errorf("%s: expected create error", msg)
+	}
+}
+
+func TestArchiveFS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wsarchive")
+	if err != nil {
+		t.Fatal("failed to create temp dir")
+	}
+	defer os.RemoveAll(dir)
+
+	zpath := filepath.Join(dir, "rel.zip")
+	f, err := os.Create(zpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for _, af := range archiveFiles {
+		w, err := zw.Create(af.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(af.data))
+	}
+	zw.Close()
+	f.Close()
+	fs, err := NewArchiveFS("/mnt/rel", zpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTree(t, "zip", fs)
+
+	tpath := filepath.Join(dir, "rel.tar.gz")
+	f, err = os.Create(tpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for _, af := range archiveFiles {
+		tw.WriteHeader(&tar.Header{Name: af.name, Mode: 0644, Size: int64(len(af.data)), Typeflag: tar.TypeReg})
+		tw.Write([]byte(af.data))
+	}
+	tw.WriteHeader(&tar.Header{Name: "../escape", Mode: 0644, Typeflag: tar.TypeReg})
+	tw.Close()
+	gz.Close()
+	f.Close()
+	fs, err = NewArchiveFS("/mnt/rel", tpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTree(t, "tar", fs)
+	if _, err := fs.Lstat("/mnt/escape"); err == nil {
+		t.Error("tar entry outside the mount")
+	}
+}
+
+func TestGitFS(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	dir, err := ioutil.TempDir("", "wsgit")
+	if err != nil {
+		t.Fatal("failed to create temp dir")
+	}
+	defer os.RemoveAll(dir)
+	for _, f := range archiveFiles {
+		path := filepath.Join(dir, f.name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := ioutil.WriteFile(path, []byte(f.data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "."},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "init"},
+		{"tag", "v1"},
+	} {
+		if _, err := git(dir, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// changes after the tag are not visible
+	ioutil.WriteFile(filepath.Join(dir, "README"), []byte("changed\n"), 0644)
+	fs, err := NewGitFS("/mnt/rel", dir, "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTree(t, "git", fs)
+	if _, err := NewGitFS("/mnt/rel", dir, "missing"); err == nil {
+		t.Error("expected error for missing revision")
+	}
+	if _, err := NewGitFS("/mnt/rel", dir, "--output=/tmp/x"); err == nil {
+		t.Error("expected error for option revision")
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ws
+
+import (
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+type ckey struct {
+	id   Id
+	name string
+}
+
+// cevent holds the folded events of a resource.
+type cevent struct {
+	// exist is the last Create or Delete, modify only otherwise
+	exist    Op
+	deadline time.Time
+}
+
+// coalescer implements a controller that folds event bursts per resource within
+// a window into their net operation before passing them to the workspace.
+type coalescer struct {
+	sync.Mutex
+	ctrl    Controller
+	find    func(id Id, name string) (p, r *Res)
+	window  time.Duration
+	keys    []ckey
+	pending map[ckey]*cevent
+	timer   *time.Timer
+}
+
+func newCoalescer(w *ctrl, window time.Duration) *coalescer {
+	return &coalescer{
+		ctrl: w,
+		find: func(id Id, name string) (p, r *Res) {
+			w.RLock()
+			defer w.RUnlock()
+			return w.find(id, name)
+		},
+		window:  window,
+		pending: make(map[ckey]*cevent),
+	}
+}
+
+func (c *coalescer) Control(op Op, id Id, name string) error {
+	c.Lock()
+	defer c.Unlock()
+	c.fold(ckey{id, name}, op)
+	return nil
+}
+
+func (c *coalescer) fold(k ckey, op Op) {
+	e := c.pending[k]
+	if e == nil {
+		e = &cevent{deadline: time.Now().Add(c.window)}
+		c.pending[k] = e
+		c.keys = append(c.keys, k)
+		if c.timer == nil {
+			c.timer = time.AfterFunc(c.window, c.flush)
+		}
+	}
+	if op&(Create|Delete) != 0 {
+		e.exist = op & (Create | Delete)
+	}
+}
+
+// Move folds moves of newly created files into a create of the target.
+// Other moves are passed on after pending events of both resources.
+func (c *coalescer) Move(from Id, fromname string, to Id, toname string) error {
+	fk, tk := ckey{from, fromname}, ckey{to, toname}
+	_, r := c.find(from, fromname)
+	c.Lock()
+	if e := c.pending[fk]; e != nil && e.exist == Create && r == nil {
+		c.pending[fk] = &cevent{exist: Delete, deadline: e.deadline}
+		c.fold(tk, Create)
+		c.Unlock()
+		return nil
+	}
+	events := c.take(func(k ckey) bool { return k == fk || k == tk })
+	c.Unlock()
+	c.send(events)
+	return c.ctrl.Move(from, fromname, to, toname)
+}
+
+// Rescan passes on all pending events before the rescan.
+func (c *coalescer) Rescan(since time.Time) error {
+	c.Lock()
+	events := c.take(func(ckey) bool { return true })
+	c.Unlock()
+	c.send(events)
+	return c.ctrl.Rescan(since)
+}
+
+// stop drops pending events.
+func (c *coalescer) stop() {
+	c.Lock()
+	defer c.Unlock()
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	c.keys, c.pending = nil, make(map[ckey]*cevent)
+}
+
+type cpending struct {
+	ckey
+	*cevent
+}
+
+// take removes and returns the pending events with keys matching f in order.
+// The caller must hold the lock.
+func (c *coalescer) take(f func(ckey) bool) []cpending {
+	var res []cpending
+	keys := c.keys[:0]
+	for _, k := range c.keys {
+		if e := c.pending[k]; f(k) {
+			res = append(res, cpending{k, e})
+			delete(c.pending, k)
+		} else {
+			keys = append(keys, k)
+		}
+	}
+	c.keys = keys
+	return res
+}
+
+func (c *coalescer) flush() {
+	c.Lock()
+	now := time.Now()
+	events := c.take(func(k ckey) bool { return !c.pending[k].deadline.After(now) })
+	c.timer = nil
+	if len(c.keys) > 0 {
+		c.timer = time.AfterFunc(c.pending[c.keys[0]].deadline.Sub(now), c.flush)
+	}
+	c.Unlock()
+	c.send(events)
+}
+
+// send passes the net operations of events to the workspace.
+func (c *coalescer) send(events []cpending) {
+	for _, e := range events {
+		var ops []Op
+		switch _, r := c.find(e.id, e.name); {
+		case e.exist == Delete:
+			ops = []Op{Delete}
+		case e.exist == Create && r == nil:
+			ops = []Op{Create}
+		case e.exist == Create && (r.Flag&FlagDir != 0 || retyped(r)):
+			// replaced directories and resources of another type are read again
+			ops = []Op{Delete, Create}
+		default:
+			// replaced or modified files
+			ops = []Op{Modify}
+		}
+		for _, op := range ops {
+			if err := c.ctrl.Control(op, e.id, e.name); err != nil {
+				log.Println("coalesce:", err)
+			}
+		}
+	}
+}
+
+// retyped returns whether the file type on disk differs from the resource r.
+func retyped(r *Res) bool {
+	r.Lock()
+	path, fs := r.path(false), r.fs(false)
+	link, dir := r.Flag&FlagLink != 0, r.Flag&FlagDir != 0
+	r.Unlock()
+	fi, err := fs.Lstat(path)
+	if err != nil {
+		return false
+	}
+	if islink := fi.Mode()&os.ModeSymlink != 0; islink || link {
+		return islink != link
+	}
+	return fi.IsDir() != dir
+}
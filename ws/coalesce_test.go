@@ -0,0 +1,84 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ws
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCoalesce(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wscoalesce")
+	if err != nil {
+		t.Fatal("failed to create temp dir")
+	}
+	defer os.RemoveAll(dir)
+	fail := func(msg string, err error) {
+		if err != nil {
+			t.Fatalf("%s: %s", msg, err)
+		}
+	}
+	fail("create a", ioutil.WriteFile(dir+"/a", nil, 0666))
+	fail("create d", os.Mkdir(dir+"/d", 0777))
+	fail("create f", ioutil.WriteFile(dir+"/f", nil, 0666))
+	events := make(testhandler, 20)
+	w := New(Config{CapHint: 100, Handler: events, Coalesce: 20 * time.Millisecond})
+	defer w.Close()
+	_, err = w.Mount(dir)
+	fail("mount", err)
+	for len(events) > 0 {
+		<-events
+	}
+	c, id := w.ctrler, NewId(dir)
+	// editor saves by replacing the file
+	c.Control(Delete, id, "a")
+	c.Control(Create, id, "a")
+	c.Control(Modify, id, "a")
+	// editor saves via a temporary file
+	fail("write tmp", ioutil.WriteFile(dir+"/tmp", []byte("x"), 0666))
+	c.Control(Create, id, "tmp")
+	c.Control(Modify, id, "tmp")
+	fail("rename tmp", os.Rename(dir+"/tmp", dir+"/d/b"))
+	c.Move(id, "tmp", NewId(dir+"/d"), "b")
+	// short lived file
+	c.Control(Create, id, "gone")
+	c.Control(Delete, id, "gone")
+	// new file
+	fail("write new", ioutil.WriteFile(dir+"/new", nil, 0666))
+	c.Control(Create, id, "new")
+	c.Control(Modify, id, "new")
+	// file replaced by a directory
+	fail("remove f", os.Remove(dir+"/f"))
+	fail("mkdir f", os.Mkdir(dir+"/f", 0777))
+	fail("write f/x", ioutil.WriteFile(dir+"/f/x", nil, 0666))
+	c.Control(Delete, id, "f")
+	c.Control(Create, id, "f")
+	expect := []testevent{
+		{Change | Modify, dir + "/a"},
+		{Add | Create, dir + "/d/b"},
+		{Add | Create, dir + "/new"},
+		{Remove | Delete, dir + "/f"},
+		{Add | Create, dir + "/f"},
+		{Add | Create, dir + "/f/x"},
+		{Change | Create, dir + "/f"},
+	}
+	for _, want := range expect {
+		select {
+		case e := <-events:
+			if e != want {
+				t.Errorf("expected event %x %q got %x %q", want.Op, want.Path, e.Op, e.Path)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected event %x %q got timeout", want.Op, want.Path)
+		}
+	}
+	select {
+	case e := <-events:
+		t.Errorf("unexpected event %x %q", e.Op, e.Path)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -4,21 +4,27 @@
 
 package ws
 
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
 // ctrl implements a workspace controller.
 type ctrl Ws
 
 func (w *ctrl) Control(op Op, id Id, name string) error {
-	var r, p *Res
+	var s *sum
+	if op&Modify != 0 {
+		// hash before locking the workspace
+		s = w.filesum(id, name)
+	}
 	w.Lock()
 	defer w.Unlock()
-	r = w.all[id]
-	if name != "" {
-		p, r = r, nil
-		if p != nil && p.Dir != nil {
-			p.Lock()
-			r = find(p.Children, name)
-			p.Unlock()
-		}
+	p, r := w.find(id, name)
+	if p != nil && op&(Create|Delete) != 0 {
+		// update the directory modification time
+		restat(p)
 	}
 	switch {
 	case op&Delete != 0:
@@ -27,8 +33,16 @@ func (w *ctrl) Control(op Op, id Id, name string) error {
 		}
 		return w.remove(op, r)
 	case r != nil:
+		if w.echoed(op&(Create|Modify), r) {
+			// caused by a workspace mutation
+			if err := restat(r); err != nil {
+				return err
+			}
+			rehash(r, s)
+			return nil
+		}
 		// res found, modify
-		return w.change(op, r)
+		return w.change(op, r, s)
 	case p != nil:
 		// parent found create child
 		return w.add(op, p, name)
@@ -36,8 +50,185 @@ func (w *ctrl) Control(op Op, id Id, name string) error {
 	// not found, ignore
 	return nil
 }
-func (w *ctrl) change(fsop Op, r *Res) error {
-	w.config.handle(fsop|Change, r)
+func (w *ctrl) Move(from Id, fromname string, to Id, toname string) error {
+	// hash replaced files before locking the workspace
+	s := w.filesum(to, toname)
+	w.Lock()
+	defer w.Unlock()
+	pa, r := w.find(from, fromname)
+	p, t := w.find(to, toname)
+	if p != nil && p.Dir == nil {
+		p, t = nil, nil
+	}
+	for _, d := range []*Res{pa, p} {
+		if d != nil {
+			restat(d)
+		}
+	}
+	switch {
+	case r == nil && p == nil:
+		// not found, ignore
+		return nil
+	case r == nil:
+		// moved into the workspace
+		return w.add(Create, p, toname)
+	case p == nil:
+		// moved out of the workspace
+		return w.remove(Delete, r)
+	case t == r:
+		return nil
+	case t != nil && t.Dir == nil && r.Dir == nil:
+		// replaced a file, usually by an editor saving a temporary file
+		if err := w.remove(Delete, r); err != nil {
+			return err
+		}
+		return w.change(Modify, t, s)
+	case t != nil:
+		if err := w.remove(Delete, t); err != nil {
+			return err
+		}
+	}
+	return w.move(p, r, toname)
+}
+
+// rescandir holds a directory snapshot used for rescans.
+type rescandir struct {
+	id       Id
+	path     string
+	mount    bool
+	mod      time.Time
+	children map[string]pollentry
+	fs       FS
+}
+
+func (w *ctrl) Rescan(since time.Time) error {
+	w.RLock()
+	var mounts []*Res
+	walk(w.root.Children, func(r *Res) error {
+		if r.Flag&FlagMount == 0 {
+			return nil
+		}
+		if r.Flag&FlagPoll == 0 {
+			mounts = append(mounts, r)
+		}
+		return Skip
+	})
+	dirs := rescandirs(mounts, FlagPoll)
+	w.RUnlock()
+	w.rescan(dirs, since, false)
+	return nil
+}
+
+// validate rescans the directories below the mount r, that were loaded from a snapshot
+// and changed since. Modified files in unchanged directories are not detected.
+func (w *ctrl) validate(r *Res) {
+	w.RLock()
+	dirs := rescandirs([]*Res{r}, 0)
+	w.RUnlock()
+	w.rescan(dirs, time.Time{}, true)
+}
+
+// rescandirs returns snapshots of the directories in list and their descendants.
+// Directories flagged with skip are ignored. The caller must hold the read lock.
+func rescandirs(list []*Res, skip uint64) []rescandir {
+	var dirs []rescandir
+	walk(list, func(r *Res) error {
+		if r.Flag&(FlagDir|FlagIgnore|skip) != FlagDir {
+			return Skip
+		}
+		r.Lock()
+		if r.Dir == nil {
+			// workspace closed
+			r.Unlock()
+			return Skip
+		}
+		d := rescandir{r.Id, r.Dir.Path, r.Flag&FlagMount != 0, r.ModTime, make(map[string]pollentry, len(r.Children)), r.fs(false)}
+		for _, c := range r.Children {
+			// entries are read without following links
+			isdir := c.Dir != nil && c.Flag&FlagLink == 0
+			d.children[c.Name] = pollentry{isdir, c.Size, c.ModTime}
+		}
+		r.Unlock()
+		dirs = append(dirs, d)
+		return nil
+	})
+	return dirs
+}
+
+// rescan reads the directories and controls the differences. Files are reported as
+// modified if their metadata changed or if they were modified since a non-zero time.
+// Directories with unchanged modification times are skipped if lazy is true.
+func (w *ctrl) rescan(dirs []rescandir, since time.Time, lazy bool) {
+	for _, d := range dirs {
+		if lazy {
+			fi, err := d.fs.Stat(d.path)
+			if err == nil && fi.ModTime().Equal(d.mod) {
+				continue
+			}
+		}
+		entries, err := readentries(d.fs, d.path)
+		if err != nil {
+			// deleted directories are reported by their parents
+			if d.mount && os.IsNotExist(err) {
+				w.control(Delete, d.id, "")
+			}
+			continue
+		}
+		for name, old := range d.children {
+			if e, ok := entries[name]; !ok || e.dir != old.dir {
+				w.control(Delete, d.id, name)
+			}
+		}
+		for name, e := range entries {
+			old, ok := d.children[name]
+			switch {
+			case !ok || e.dir != old.dir:
+				w.control(Create, d.id, name)
+			case e.dir:
+			case e.size != old.size || !e.mod.Equal(old.mod) || !since.IsZero() && !e.mod.Before(since):
+				w.control(Modify, d.id, name)
+			}
+		}
+		w.RLock()
+		r := w.all[d.id]
+		w.RUnlock()
+		if r != nil {
+			restat(r)
+		}
+	}
+}
+func (w *ctrl) control(op Op, id Id, name string) {
+	if err := w.Control(op, id, name); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// find returns the resource with id or its child with name and parent.
+func (w *ctrl) find(id Id, name string) (p, r *Res) {
+	r = w.all[id]
+	if name != "" {
+		p, r = r, nil
+		if p != nil && p.Dir != nil {
+			p.Lock()
+			r = find(p.Children, name)
+			p.Unlock()
+		}
+	}
+	return p, r
+}
+
+// change reports the change of r. Modified files are compared with the content sum s.
+func (w *ctrl) change(fsop Op, r *Res, s *sum) error {
+	if fsop&Modify != 0 {
+		if err := restat(r); err != nil {
+			return err
+		}
+		if !rehash(r, s) {
+			// content did not change
+			return nil
+		}
+	}
+	(*Ws)(w).handle(fsop|Change, r)
 	return nil
 }
 func (w *ctrl) remove(fsop Op, r *Res) error {
@@ -62,11 +253,12 @@ func (w *ctrl) remove(fsop Op, r *Res) error {
 	}
 	for i := len(rm) - 1; i >= 0; i-- {
 		c := rm[i]
-		w.config.handle(fsop|Remove, c)
+		(*Ws)(w).handle(fsop|Remove, c)
 		if c.Dir != nil {
 			c.Children = nil
+			w.unwatch(c.Id)
 		}
-		delete(w.all, c.Id)
+		(*Ws)(w).drop(c)
 		c.Unlock()
 	}
 	return nil
@@ -79,24 +271,115 @@ func (w *ctrl) add(fsop Op, p *Res, name string) error {
 		// ignore duplicate
 		return nil
 	}
-	r, err := newChild(p, name, false, true)
+	r, err := newChild(p, name, nil, w.config.Links)
+	if os.IsNotExist(err) {
+		// already removed again
+		return nil
+	}
 	if err != nil {
 		return err
 	}
 	p.Children = insert(p.Children, r)
-	w.all[r.Id] = r
-	switch {
-	case w.config.filter(r):
+	// filter before put to keep ignored resources out of the query index
+	if w.config.filter(r) {
 		r.Flag |= FlagIgnore
-		fallthrough
-	case r.Dir == nil:
-		w.config.handle(fsop|Add, r)
+	}
+	(*Ws)(w).put(r)
+	if r.Flag&FlagIgnore != 0 || r.Dir == nil {
+		(*Ws)(w).handle(fsop|Add, r)
 		return nil
 	}
-	if err = read(r, w.config.Filter); err != nil {
+	if err = read(r, &w.config); err != nil {
 		return err
 	}
-	w.config.handle(fsop|Add, r)
+	(*Ws)(w).handle(fsop|Add, r)
 	(*Ws)(w).addAllChildren(fsop, r)
 	return nil
 }
+func (w *ctrl) move(p, r *Res, name string) error {
+	mv := []*Res{r}
+	if r.Dir != nil {
+		walk(r.Children, func(c *Res) error {
+			mv = append(mv, c)
+			return nil
+		})
+	}
+	var dirs []Id
+	for i := len(mv) - 1; i >= 0; i-- {
+		c := mv[i]
+		(*Ws)(w).handle(Move|Remove, c)
+		(*Ws)(w).drop(c)
+		if c.Dir != nil {
+			dirs = append(dirs, c.Id)
+		}
+	}
+	r.Lock()
+	if pa := r.Parent; pa != nil {
+		pa.Lock()
+		if pa.Dir != nil {
+			pa.Children = remove(pa.Children, r)
+		}
+		pa.Unlock()
+	}
+	r.Name, r.Parent = name, p
+	r.Unlock()
+	w.rekey(r, p.Path())
+	p.Lock()
+	p.Children = insert(p.Children, r)
+	p.Unlock()
+	(*Ws)(w).put(r)
+	(*Ws)(w).handle(Move|Add, r)
+	if r.Flag&(FlagDir|FlagIgnore) == FlagDir {
+		(*Ws)(w).addAllChildren(Move, r)
+	}
+	// unwatch old ids after the new ids are watched
+	for _, id := range dirs {
+		w.unwatch(id)
+	}
+	return nil
+}
+
+// rekey updates the id, path and flags of the moved resource r and its descendants.
+// Directories that are no longer ignored are read, newly ignored directories are emptied.
+func (w *ctrl) rekey(r *Res, dir string) {
+	r.Lock()
+	path := join(dir, r.Name)
+	ignored := r.Flag&FlagIgnore != 0
+	r.Id = NewId(path)
+	r.Flag = r.Flag&(FlagDir|FlagLink) | r.Parent.Flag&(FlagPoll|FlagReadOnly)
+	if r.Dir != nil {
+		r.Dir.Path = path
+	}
+	r.Unlock()
+	if w.config.filter(r) {
+		r.Flag |= FlagIgnore
+	}
+	if r.Dir == nil {
+		return
+	}
+	switch {
+	case r.Flag&FlagIgnore != 0:
+		r.Lock()
+		r.Children = nil
+		r.Unlock()
+	case ignored:
+		if err := read(r, &w.config); err != nil {
+			fmt.Println(err)
+		}
+	default:
+		for _, c := range r.Children {
+			w.rekey(c, path)
+		}
+	}
+}
+func (w *ctrl) unwatch(id Id) {
+	watchers := []Watcher{w.watcher, w.poller}
+	for _, watcher := range w.fswatchers {
+		watchers = append(watchers, watcher)
+	}
+	for _, watcher := range watchers {
+		if watcher != nil {
+			watcher.Unwatch(id)
+		}
+	}
+}
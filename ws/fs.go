@@ -0,0 +1,116 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ws
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// FS provides access to the files of mounted trees.
+// Paths are the absolute workspace paths of the resources.
+type FS interface {
+	// Stat returns the file info for path following links.
+	Stat(path string) (os.FileInfo, error)
+	// Lstat returns the file info for path without following links.
+	Lstat(path string) (os.FileInfo, error)
+	// ReadDir returns the file infos of the entries of the directory at path.
+	ReadDir(path string) ([]os.FileInfo, error)
+	// Readlink returns the target of the link at path.
+	Readlink(path string) (string, error)
+	// Open opens the file at path for reading.
+	Open(path string) (io.ReadCloser, error)
+	// Watcher returns a new watcher for the filesystem given workspace control,
+	// or nil if the filesystem is not watched. Disk mounts use the configured watcher.
+	Watcher(Controller) (Watcher, error)
+}
+
+// ReadOnlyFS is implemented by filesystems that cannot be changed.
+// Resources of read-only mounts are flagged with FlagReadOnly.
+type ReadOnlyFS interface {
+	FS
+	ReadOnly() bool
+}
+
+func readOnly(fs FS) bool {
+	ro, ok := fs.(ReadOnlyFS)
+	return ok && ro.ReadOnly()
+}
+
+// Disk is the filesystem of the operating system.
+var Disk FS = disk{}
+
+type disk struct{}
+
+func (disk) Stat(path string) (os.FileInfo, error) {
+	return os.Stat(path)
+}
+func (disk) Lstat(path string) (os.FileInfo, error) {
+	return os.Lstat(path)
+}
+func (disk) ReadDir(path string) ([]os.FileInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Readdir(-1)
+}
+func (disk) Readlink(path string) (string, error) {
+	return os.Readlink(path)
+}
+func (disk) Open(path string) (io.ReadCloser, error) {
+	return os.Open(path)
+}
+func (disk) Watcher(Controller) (Watcher, error) {
+	return nil, nil
+}
+
+// Open opens the file resource for reading.
+func (r *Res) Open() (io.ReadCloser, error) {
+	r.Lock()
+	fs := r.fs(false)
+	r.Unlock()
+	return fs.Open(r.Path())
+}
+
+// FS returns the filesystem of the resource.
+func (r *Res) FS() FS {
+	return r.fs(true)
+}
+
+// FS returns the filesystem of the directory. It does not lock the resource
+// and may be used by filters while the tree is read.
+func (d *Dir) FS() FS {
+	if d.fs == nil {
+		return Disk
+	}
+	return d.fs
+}
+
+func (r *Res) fs(lock bool) FS {
+	if r == nil {
+		return Disk
+	}
+	if lock {
+		r.Lock()
+		defer r.Unlock()
+	}
+	if r.Dir != nil && r.Dir.fs != nil {
+		return r.Dir.fs
+	}
+	return r.Parent.fs(lock)
+}
+
+// readAll reads the file at path from fs.
+func readAll(fs FS, path string) ([]byte, error) {
+	f, err := fs.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
@@ -0,0 +1,89 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ws
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NewGitFS returns a read-only filesystem with the tree of the git tree-ish rev in the
+// repository at repo to be mounted at path. The git binary is used to list the tree and
+// file contents are read when opened.
+func NewGitFS(path, repo, rev string) (FS, error) {
+	if rev == "" || rev[0] == '-' {
+		// revisions must not be read as git options
+		return nil, fmt.Errorf("invalid git revision %q", rev)
+	}
+	mod := time.Now()
+	// trees without commit use the current time
+	if out, err := git(repo, "log", "-1", "--format=%ct", rev); err == nil {
+		if sec, err := strconv.ParseInt(string(bytes.TrimSpace(out)), 10, 64); err == nil {
+			mod = time.Unix(sec, 0)
+		}
+	}
+	out, err := git(repo, "ls-tree", "-r", "-l", "-z", "--full-tree", rev)
+	if err != nil {
+		return nil, err
+	}
+	t := newTreeFS(path, mod)
+	t.open = func(f *treefile) (io.ReadCloser, error) {
+		data, err := git(repo, "cat-file", "blob", f.ref)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(bytes.NewReader(data)), nil
+	}
+	for _, entry := range strings.Split(string(out), "\x00") {
+		// <mode> SP <type> SP <object> SP+ <size> TAB <name>
+		i := strings.IndexByte(entry, '\t')
+		if i < 0 {
+			continue
+		}
+		meta, name := strings.Fields(entry[:i]), entry[i+1:]
+		if len(meta) != 4 {
+			return nil, fmt.Errorf("git ls-tree: unexpected entry %q", entry)
+		}
+		f := &treefile{mode: 0444, mod: mod, ref: meta[2]}
+		switch meta[0] {
+		case "100755":
+			f.mode = 0555
+		case "120000":
+			data, err := git(repo, "cat-file", "blob", f.ref)
+			if err != nil {
+				return nil, err
+			}
+			f.mode, f.link, f.ref = os.ModeSymlink|0777, string(data), ""
+		case "160000":
+			// submodules are empty directories
+			f.mode, f.ref = os.ModeDir|0555, ""
+		}
+		if meta[1] == "blob" && f.ref != "" {
+			f.size, _ = strconv.ParseInt(meta[3], 10, 64)
+		}
+		t.add(name, f)
+	}
+	return t, nil
+}
+
+// git runs the git binary in dir with args and returns its output.
+func git(dir string, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s: %s %s", args[0], err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return out, nil
+}
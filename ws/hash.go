@@ -0,0 +1,112 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ws
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"time"
+)
+
+// HashLimit is the maximum size of files that are hashed.
+// Modifications of larger files are never verified.
+var HashLimit int64 = 1 << 24
+
+// Hash is the sha1 hash of the content of a file resource.
+type Hash [sha1.Size]byte
+
+func (h Hash) String() string {
+	return fmt.Sprintf("%x", h[:])
+}
+
+// sum holds a content hash and the file size and modification time it was computed for.
+type sum struct {
+	hash    Hash
+	size    int64
+	modtime time.Time
+}
+
+// Hash returns the content hash of the file resource.
+// The hash is computed when first requested and again after the file changed.
+func (r *Res) Hash() (Hash, error) {
+	r.Lock()
+	if r.Dir != nil {
+		r.Unlock()
+		return Hash{}, fmt.Errorf("not a file")
+	}
+	if s := r.sum; s != nil && s.size == r.Size && s.modtime.Equal(r.ModTime) {
+		r.Unlock()
+		return s.hash, nil
+	}
+	fs, size, modtime := r.fs(false), r.Size, r.ModTime
+	r.Unlock()
+	h, err := hashFile(fs, r.Path())
+	if err != nil {
+		return h, err
+	}
+	r.Lock()
+	// discard hashes of files that changed meanwhile
+	if r.Size == size && r.ModTime.Equal(modtime) {
+		r.sum = &sum{h, size, modtime}
+	}
+	r.Unlock()
+	return h, nil
+}
+
+// filesum returns the content sum of the file with id or its child with name.
+// It returns nil for directories and files that are missing or larger than HashLimit.
+// The workspace is only locked to find the file, which is hashed without locks.
+func (w *ctrl) filesum(id Id, name string) *sum {
+	w.RLock()
+	_, r := w.find(id, name)
+	w.RUnlock()
+	if r == nil || restat(r) != nil {
+		return nil
+	}
+	r.Lock()
+	if r.Dir != nil || r.Size > HashLimit {
+		r.Unlock()
+		return nil
+	}
+	fs, path, size, modtime := r.fs(false), r.path(false), r.Size, r.ModTime
+	r.Unlock()
+	h, err := hashFile(fs, path)
+	if err != nil {
+		return nil
+	}
+	return &sum{h, size, modtime}
+}
+
+// rehash stores the content sum s of the modified file resource r and returns
+// whether the content differs from the previous sum. Files without previous sum
+// are reported as changed. Sums not matching the current metadata of r are
+// discarded. The caller must hold the write lock.
+func rehash(r *Res, s *sum) bool {
+	r.Lock()
+	defer r.Unlock()
+	old := r.sum
+	if s == nil || s.size != r.Size || !s.modtime.Equal(r.ModTime) {
+		r.sum = nil
+		return true
+	}
+	r.sum = s
+	return old == nil || old.hash != s.hash
+}
+
+func hashFile(fs FS, path string) (Hash, error) {
+	var h Hash
+	f, err := fs.Open(path)
+	if err != nil {
+		return h, err
+	}
+	defer f.Close()
+	s := sha1.New()
+	if _, err = io.Copy(s, f); err != nil {
+		return h, err
+	}
+	copy(h[:], s.Sum(nil))
+	return h, nil
+}
@@ -0,0 +1,68 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ws
+
+import (
+	"crypto/sha1"
+	"testing"
+)
+
+func TestHash(t *testing.T) {
+	m := NewMemFS()
+	m.MkdirAll("/proj")
+	m.WriteFile("/proj/a", []byte("a"))
+	events := make(testhandler, 20)
+	w := New(Config{CapHint: 100, Handler: events})
+	defer w.Close()
+	if _, err := w.MountFS("/proj", m); err != nil {
+		t.Fatal(err)
+	}
+	for len(events) > 0 {
+		<-events
+	}
+	r := w.Res(NewId("/proj/a"))
+	h, err := r.Hash()
+	if err != nil || h != Hash(sha1.Sum([]byte("a"))) {
+		t.Fatalf("hash %s %v", h, err)
+	}
+	if _, err := w.Res(NewId("/proj")).Hash(); err == nil {
+		t.Error("expected error hashing a directory")
+	}
+	writes := []struct {
+		data   string
+		change bool
+	}{
+		{"a", false},
+		{"b", true},
+		{"b", false},
+		{"a", true},
+		{"abc", true},
+		{"abc", false},
+		{"abd", true},
+	}
+	for _, wr := range writes {
+		m.WriteFile("/proj/a", []byte(wr.data))
+		if n := len(events); wr.change != (n > 0) {
+			t.Errorf("write %q: want change %v got %d events", wr.data, wr.change, n)
+		}
+		for len(events) > 0 {
+			if e := <-events; e.Op != Change|Modify {
+				t.Errorf("write %q: unexpected event %x", wr.data, e.Op)
+			}
+		}
+		if h, _ := r.Hash(); h != Hash(sha1.Sum([]byte(wr.data))) {
+			t.Errorf("write %q: hash %s", wr.data, h)
+		}
+	}
+	// modified files are hashed without requesting the hash
+	m.WriteFile("/proj/c", []byte("c"))
+	for len(events) > 0 {
+		<-events
+	}
+	m.WriteFile("/proj/c", []byte("c"))
+	if n := len(events); n > 0 {
+		t.Errorf("unchanged write of unhashed file: got %d events", n)
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ws
+
+import (
+	"bufio"
+	"path"
+	"strings"
+	"sync"
+)
+
+// Ignore implements a filter for gitignore style patterns.
+// Patterns are read from pattern files in each directory of a mount. The global
+// patterns apply to all mounts and have the lowest precedence.
+// Changed pattern files only apply to resources added afterwards.
+type Ignore struct {
+	sync.Mutex
+	file   string
+	global []pattern
+	dirs   map[Id][]pattern
+}
+
+// NewIgnore returns a filter for the global patterns and the pattern files
+// named file, usually ".gitignore". Pattern files are not read if file is empty.
+func NewIgnore(file string, global []string) *Ignore {
+	ig := &Ignore{file: file, dirs: make(map[Id][]pattern)}
+	for _, line := range global {
+		if p, ok := parsePattern(line); ok {
+			ig.global = append(ig.global, p)
+		}
+	}
+	return ig
+}
+
+// Filter returns whether the resource r matches the patterns.
+func (ig *Ignore) Filter(r *Res) bool {
+	var dirs []*Res
+	for a := r.Parent; a != nil && a.Flag&FlagLogical == 0; a = a.Parent {
+		dirs = append(dirs, a)
+		if a.Flag&FlagMount != 0 {
+			break
+		}
+	}
+	// segs holds the path relative to the mount
+	segs := make([]string, 0, len(dirs)+1)
+	segs = append(segs, r.Name)
+	for i := 0; i < len(dirs)-1; i++ {
+		segs = append(segs, dirs[i].Name)
+	}
+	for i, j := 0, len(segs)-1; i < j; i, j = i+1, j-1 {
+		segs[i], segs[j] = segs[j], segs[i]
+	}
+	isdir := r.Flag&FlagDir != 0
+	ignored := matchAll(ig.global, segs, isdir, false)
+	for i := len(dirs) - 1; i >= 0; i-- {
+		ignored = matchAll(ig.patterns(dirs[i]), segs[len(segs)-1-i:], isdir, ignored)
+	}
+	return ignored
+}
+
+// Handle drops the cached patterns of changed pattern files.
+func (ig *Ignore) Handle(op Op, r *Res) {
+	if ig.file == "" || r.Name != ig.file || r.Parent == nil {
+		return
+	}
+	ig.Lock()
+	defer ig.Unlock()
+	delete(ig.dirs, r.Parent.Id)
+}
+
+func (ig *Ignore) patterns(d *Res) []pattern {
+	if ig.file == "" || d.Dir == nil {
+		return nil
+	}
+	ig.Lock()
+	list, ok := ig.dirs[d.Id]
+	ig.Unlock()
+	if ok {
+		return list
+	}
+	list = readPatterns(d.fs(false), join(d.Dir.Path, ig.file))
+	ig.Lock()
+	ig.dirs[d.Id] = list
+	ig.Unlock()
+	return list
+}
+
+func readPatterns(fs FS, path string) []pattern {
+	f, err := fs.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var list []pattern
+	scan := bufio.NewScanner(f)
+	for scan.Scan() {
+		if p, ok := parsePattern(scan.Text()); ok {
+			list = append(list, p)
+		}
+	}
+	return list
+}
+
+type pattern struct {
+	parts  []string
+	negate bool
+	dir    bool
+}
+
+// parsePattern parses a gitignore pattern line.
+// Patterns without inner slash match at any depth.
+func parsePattern(line string) (p pattern, ok bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || line[0] == '#' {
+		return p, false
+	}
+	switch line[0] {
+	case '!':
+		p.negate = true
+		line = line[1:]
+	case '\\':
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		p.dir = true
+		line = strings.TrimRight(line, "/")
+	}
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimLeft(line, "/")
+	if line == "" {
+		return p, false
+	}
+	p.parts = strings.Split(line, "/")
+	if !anchored {
+		p.parts = append([]string{"**"}, p.parts...)
+	}
+	return p, true
+}
+
+func matchAll(list []pattern, segs []string, isdir, ignored bool) bool {
+	for _, p := range list {
+		if (!p.dir || isdir) && match(p.parts, segs) {
+			ignored = !p.negate
+		}
+	}
+	return ignored
+}
+
+func match(parts, segs []string) bool {
+	for len(parts) > 0 {
+		if parts[0] == "**" {
+			rest := parts[1:]
+			if len(rest) == 0 {
+				return len(segs) > 0
+			}
+			for i := range segs {
+				if match(rest, segs[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segs) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(parts[0], segs[0]); !ok {
+			return false
+		}
+		parts, segs = parts[1:], segs[1:]
+	}
+	return len(segs) == 0
+}
@@ -0,0 +1,103 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ws
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPatternMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		isdir   bool
+		match   bool
+	}{
+		{"*.o", "a.o", false, true},
+		{"*.o", "sub/a.o", false, true},
+		{"*.o", "a.go", false, false},
+		{"/build", "build", true, true},
+		{"/build", "sub/build", true, false},
+		{"build/", "sub/build", true, true},
+		{"build/", "sub/build", false, false},
+		{"doc/*.html", "doc/a.html", false, true},
+		{"doc/*.html", "sub/doc/a.html", false, false},
+		{"**/doc/*.html", "sub/doc/a.html", false, true},
+		{"a/**/b", "a/b", false, true},
+		{"a/**/b", "a/x/y/b", false, true},
+		{"a/**", "a", true, false},
+		{"a/**", "a/x", false, true},
+		{"\\#hash", "#hash", false, true},
+	}
+	for _, test := range tests {
+		p, ok := parsePattern(test.pattern)
+		if !ok {
+			t.Errorf("pattern %q not parsed", test.pattern)
+			continue
+		}
+		got := matchAll([]pattern{p}, strings.Split(test.path, "/"), test.isdir, false)
+		if got != test.match {
+			t.Errorf("pattern %q path %q expected %v got %v", test.pattern, test.path, test.match, got)
+		}
+	}
+	for _, line := range []string{"", "# comment", "   ", "/"} {
+		if _, ok := parsePattern(line); ok {
+			t.Errorf("line %q parsed as pattern", line)
+		}
+	}
+}
+
+func TestIgnore(t *testing.T) {
+	m := NewMemFS()
+	files := map[string]string{
+		"/.gitignore":          "*.o\n!keep.o\n/build/\nlogs/\n",
+		"/a.o":                 "",
+		"/keep.o":              "",
+		"/build/out":           "",
+		"/sub/.gitignore":      "*.tmp\n!important.tmp\nkeep.o\n",
+		"/sub/x.tmp":           "",
+		"/sub/important.tmp":   "",
+		"/sub/keep.o":          "",
+		"/sub/build/out":       "",
+		"/sub/logs/log":        "",
+		"/sub/node_modules/js": "",
+	}
+	for name, data := range files {
+		path := "/m" + name
+		m.MkdirAll(path[:strings.LastIndex(path, "/")])
+		m.WriteFile(path, []byte(data))
+	}
+	w := New(Config{CapHint: 100, Filter: NewIgnore(".gitignore", []string{"node_modules/"})})
+	defer w.Close()
+	if _, err := w.MountFS("/m", m); err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]bool{
+		"/a.o":               true,
+		"/keep.o":            false,
+		"/build":             true,
+		"/sub/x.tmp":         true,
+		"/sub/important.tmp": false,
+		"/sub/keep.o":        true,
+		"/sub/build":         false,
+		"/sub/build/out":     false,
+		"/sub/logs":          true,
+		"/sub/node_modules":  true,
+	}
+	for name, ignored := range expect {
+		r := w.Res(NewId("/m" + name))
+		if r == nil {
+			t.Errorf("resource %s not found", name)
+			continue
+		}
+		if got := r.Flag&FlagIgnore != 0; got != ignored {
+			t.Errorf("resource %s expected ignored %v got %v", name, ignored, got)
+		}
+	}
+	if w.Res(NewId("/m/build/out")) != nil {
+		t.Error("ignored directory was read")
+	}
+}
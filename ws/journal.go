@@ -0,0 +1,85 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ws
+
+import (
+	"time"
+)
+
+// DefaultJournalSize is used by workspaces without a configured journal size.
+const DefaultJournalSize = 4096
+
+// Entry is a journaled workspace event.
+type Entry struct {
+	Seq  uint64
+	Op   Op
+	Id   Id
+	Path string
+}
+
+// journal holds the latest workspace events in a ring buffer.
+// Sequence numbers start at the creation time in microseconds, so that numbers
+// from previous processes are not mistaken for journaled events.
+type journal struct {
+	seq     uint64
+	entries []Entry
+	// next is the index of the oldest entry once the buffer is full.
+	next int
+}
+
+func newJournal(size int) journal {
+	if size <= 0 {
+		size = DefaultJournalSize
+	}
+	return journal{
+		seq:     uint64(time.Now().UnixNano() / 1000),
+		entries: make([]Entry, 0, size),
+	}
+}
+
+func (j *journal) add(op Op, id Id, path string) uint64 {
+	j.seq++
+	e := Entry{j.seq, op, id, path}
+	if len(j.entries) < cap(j.entries) {
+		j.entries = append(j.entries, e)
+	} else {
+		j.entries[j.next] = e
+		j.next = (j.next + 1) % len(j.entries)
+	}
+	return j.seq
+}
+
+func (j *journal) since(seq uint64) ([]Entry, bool) {
+	if seq >= j.seq {
+		return nil, seq == j.seq
+	}
+	n := j.seq - seq
+	if n > uint64(len(j.entries)) {
+		return nil, false
+	}
+	l := len(j.entries)
+	start := (j.next + l - int(n)) % l
+	res := make([]Entry, n)
+	for i := range res {
+		res[i] = j.entries[(start+i)%l]
+	}
+	return res, true
+}
+
+// Seq returns the sequence number of the last workspace event.
+func (w *Ws) Seq() uint64 {
+	w.RLock()
+	defer w.RUnlock()
+	return w.journal.seq
+}
+
+// Since returns the journaled events after the sequence number seq in order.
+// It returns false if seq is unknown or events after seq were dropped from the
+// journal, callers must then reload the resources they are interested in.
+func (w *Ws) Since(seq uint64) ([]Entry, bool) {
+	w.RLock()
+	defer w.RUnlock()
+	return w.journal.since(seq)
+}
@@ -0,0 +1,72 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ws
+
+import (
+	"testing"
+)
+
+func TestJournal(t *testing.T) {
+	j := newJournal(3)
+	start := j.seq
+	for i := 0; i < 5; i++ {
+		j.add(Add, Id(i), "")
+	}
+	if j.seq != start+5 {
+		t.Fatalf("want seq %d got %d", start+5, j.seq)
+	}
+	tests := []struct {
+		since uint64
+		ok    bool
+		ids   []Id
+	}{
+		{start, false, nil},
+		{start + 1, false, nil},
+		{start + 2, true, []Id{2, 3, 4}},
+		{start + 4, true, []Id{4}},
+		{start + 5, true, nil},
+		{start + 6, false, nil},
+	}
+	for _, test := range tests {
+		list, ok := j.since(test.since)
+		if ok != test.ok || len(list) != len(test.ids) {
+			t.Errorf("since %d: want %v %v got %v %v", test.since-start, test.ok, test.ids, ok, list)
+			continue
+		}
+		for i, e := range list {
+			if e.Id != test.ids[i] || e.Seq != test.since+uint64(i)+1 {
+				t.Errorf("since %d: want %v got %v", test.since-start, test.ids, list)
+				break
+			}
+		}
+	}
+
+	m := NewMemFS()
+	m.MkdirAll("/proj")
+	w := New(Config{CapHint: 100})
+	defer w.Close()
+	seq := w.Seq()
+	if _, err := w.MountFS("/proj", m); err != nil {
+		t.Fatal(err)
+	}
+	m.WriteFile("/proj/a", []byte("a"))
+	m.Remove("/proj/a")
+	list, ok := w.Since(seq)
+	want := []testevent{
+		{Add, "/proj"},
+		{Change, "/proj"},
+		{Add | Create, "/proj/a"},
+		{Change | Modify, "/proj/a"},
+		{Remove | Delete, "/proj/a"},
+	}
+	if !ok || len(list) != len(want) {
+		t.Fatalf("want %d events got %v %v", len(want), ok, list)
+	}
+	for i, e := range list {
+		if e.Op != want[i].Op || e.Path != want[i].Path || e.Seq != seq+uint64(i)+1 {
+			t.Errorf("want %x %q got %x %q", want[i].Op, want[i].Path, e.Op, e.Path)
+		}
+	}
+}
@@ -0,0 +1,413 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ws
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemFS implements an in-memory filesystem. Changes are reported synchronously
+// to the watchers of the filesystem before the changing method returns.
+type MemFS struct {
+	sync.Mutex
+	files    map[string]*memfile
+	watchers []*memwatcher
+}
+
+// memfile implements os.FileInfo.
+type memfile struct {
+	name string
+	mode os.FileMode
+	mod  time.Time
+	data []byte
+	link string
+}
+
+func (f *memfile) Name() string       { return f.name }
+func (f *memfile) Size() int64        { return int64(len(f.data)) }
+func (f *memfile) Mode() os.FileMode  { return f.mode }
+func (f *memfile) ModTime() time.Time { return f.mod }
+func (f *memfile) IsDir() bool        { return f.mode.IsDir() }
+func (f *memfile) Sys() interface{}   { return nil }
+
+// NewMemFS returns a new filesystem containing the root directory.
+func NewMemFS() *MemFS {
+	m := &MemFS{files: make(map[string]*memfile)}
+	m.files["/"] = &memfile{name: "/", mode: os.ModeDir | 0777, mod: time.Now()}
+	return m
+}
+
+type memevent struct {
+	op   Op
+	dir  string
+	name string
+	// to is the destination of moves
+	to, toname string
+}
+
+// MkdirAll creates the directory at path and all missing parents.
+func (m *MemFS) MkdirAll(path string) error {
+	m.Lock()
+	var events []memevent
+	err := m.mkdirAll(filepath.Clean(path), &events)
+	m.Unlock()
+	m.notify(events)
+	return err
+}
+
+func (m *MemFS) mkdirAll(path string, events *[]memevent) error {
+	if f, ok := m.files[path]; ok {
+		if !f.IsDir() {
+			return memerr("mkdir", path, os.ErrExist)
+		}
+		return nil
+	}
+	dir, name := filepath.Split(path)
+	dir = filepath.Clean(dir)
+	if err := m.mkdirAll(dir, events); err != nil {
+		return err
+	}
+	m.add(dir, &memfile{name: name, mode: os.ModeDir | 0777}, path, events)
+	return nil
+}
+
+// WriteFile creates or replaces the file at path with data.
+func (m *MemFS) WriteFile(path string, data []byte) error {
+	path = filepath.Clean(path)
+	m.Lock()
+	var events []memevent
+	dir, name := filepath.Split(path)
+	dir = filepath.Clean(dir)
+	err := m.parent(path, "write")
+	if err == nil {
+		f := m.files[path]
+		switch {
+		case f == nil:
+			f = &memfile{name: name, mode: 0666}
+			m.add(dir, f, path, &events)
+		case f.IsDir():
+			err = memerr("write", path, os.ErrInvalid)
+		}
+		if err == nil {
+			f.data, f.mod = append([]byte(nil), data...), time.Now()
+			events = append(events, memevent{op: Modify, dir: dir, name: name})
+		}
+	}
+	m.Unlock()
+	m.notify(events)
+	return err
+}
+
+// Symlink creates a link at path pointing to target.
+func (m *MemFS) Symlink(target, path string) error {
+	path = filepath.Clean(path)
+	m.Lock()
+	var events []memevent
+	err := m.parent(path, "symlink")
+	if err == nil {
+		if _, ok := m.files[path]; ok {
+			err = memerr("symlink", path, os.ErrExist)
+		} else {
+			dir, name := filepath.Split(path)
+			m.add(filepath.Clean(dir), &memfile{name: name, mode: os.ModeSymlink | 0777, link: target}, path, &events)
+		}
+	}
+	m.Unlock()
+	m.notify(events)
+	return err
+}
+
+// Remove removes the file or directory tree at path.
+func (m *MemFS) Remove(path string) error {
+	path = filepath.Clean(path)
+	m.Lock()
+	var events []memevent
+	if _, ok := m.files[path]; !ok {
+		m.Unlock()
+		return memerr("remove", path, os.ErrNotExist)
+	}
+	for p := range m.files {
+		if within(p, path) {
+			delete(m.files, p)
+		}
+	}
+	dir, name := filepath.Split(path)
+	dir = filepath.Clean(dir)
+	m.touch(dir)
+	events = append(events, memevent{op: Delete, dir: dir, name: name})
+	m.Unlock()
+	m.notify(events)
+	return nil
+}
+
+// Rename moves the file or directory tree at from to the path to.
+// Existing files at to are replaced.
+func (m *MemFS) Rename(from, to string) error {
+	from, to = filepath.Clean(from), filepath.Clean(to)
+	m.Lock()
+	f, ok := m.files[from]
+	err := m.parent(to, "rename")
+	switch {
+	case !ok:
+		err = memerr("rename", from, os.ErrNotExist)
+	case within(to, from):
+		err = memerr("rename", to, os.ErrInvalid)
+	case err == nil:
+		if t, ok := m.files[to]; ok && (t.IsDir() || f.IsDir()) {
+			err = memerr("rename", to, os.ErrExist)
+		}
+	}
+	if err != nil {
+		m.Unlock()
+		return err
+	}
+	moved := make(map[string]*memfile)
+	for p, c := range m.files {
+		if within(p, from) {
+			moved[to+p[len(from):]] = c
+			delete(m.files, p)
+		}
+	}
+	for p, c := range moved {
+		m.files[p] = c
+	}
+	fdir, fname := filepath.Split(from)
+	tdir, tname := filepath.Split(to)
+	fdir, tdir = filepath.Clean(fdir), filepath.Clean(tdir)
+	f.name = tname
+	m.touch(fdir)
+	m.touch(tdir)
+	events := []memevent{{op: Move, dir: fdir, name: fname, to: tdir, toname: tname}}
+	m.Unlock()
+	m.notify(events)
+	return nil
+}
+
+func (m *MemFS) parent(path, op string) error {
+	if d, ok := m.files[filepath.Dir(path)]; !ok || !d.IsDir() {
+		return memerr(op, path, os.ErrNotExist)
+	}
+	return nil
+}
+
+func (m *MemFS) add(dir string, f *memfile, path string, events *[]memevent) {
+	f.mod = time.Now()
+	m.files[path] = f
+	m.touch(dir)
+	*events = append(*events, memevent{op: Create, dir: dir, name: f.name})
+}
+
+func (m *MemFS) touch(dir string) {
+	if d, ok := m.files[dir]; ok {
+		d.mod = time.Now()
+	}
+}
+
+// stat returns a copy of the file info at path.
+func (m *MemFS) stat(path string) (*memfile, error) {
+	m.Lock()
+	defer m.Unlock()
+	f, ok := m.files[filepath.Clean(path)]
+	if !ok {
+		return nil, memerr("stat", path, os.ErrNotExist)
+	}
+	c := *f
+	return &c, nil
+}
+
+func (m *MemFS) Lstat(path string) (os.FileInfo, error) {
+	f, err := m.stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Stat returns the file info at path following one level of links.
+func (m *MemFS) Stat(path string) (os.FileInfo, error) {
+	f, err := m.stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if f.link == "" {
+		return f, nil
+	}
+	target := f.link
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(path), target)
+	}
+	t, err := m.stat(target)
+	if err != nil {
+		return nil, err
+	}
+	t.name = f.name
+	return t, nil
+}
+
+func (m *MemFS) ReadDir(path string) ([]os.FileInfo, error) {
+	path = filepath.Clean(path)
+	m.Lock()
+	defer m.Unlock()
+	if d, ok := m.files[path]; !ok || !d.IsDir() {
+		return nil, memerr("readdir", path, os.ErrNotExist)
+	}
+	var list []os.FileInfo
+	for p, f := range m.files {
+		if p != path && filepath.Dir(p) == path {
+			c := *f
+			list = append(list, &c)
+		}
+	}
+	sort.Sort(byInfoName(list))
+	return list, nil
+}
+
+func (m *MemFS) Readlink(path string) (string, error) {
+	f, err := m.stat(path)
+	if err != nil {
+		return "", err
+	}
+	if f.link == "" {
+		return "", memerr("readlink", path, os.ErrInvalid)
+	}
+	return f.link, nil
+}
+
+func (m *MemFS) Open(path string) (io.ReadCloser, error) {
+	f, err := m.stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if f.IsDir() {
+		return nil, memerr("open", path, os.ErrInvalid)
+	}
+	// data is never modified in place
+	return ioutil.NopCloser(bytes.NewReader(f.data)), nil
+}
+
+func (m *MemFS) Watcher(ctrler Controller) (Watcher, error) {
+	m.Lock()
+	defer m.Unlock()
+	mw := &memwatcher{fs: m, ctrler: ctrler, dirs: make(map[string][]Id)}
+	m.watchers = append(m.watchers, mw)
+	return mw, nil
+}
+
+// notify reports events to all watchers. The caller must not hold the lock.
+func (m *MemFS) notify(events []memevent) {
+	if len(events) == 0 {
+		return
+	}
+	m.Lock()
+	watchers := make([]*memwatcher, len(m.watchers))
+	copy(watchers, m.watchers)
+	m.Unlock()
+	for _, mw := range watchers {
+		for _, e := range events {
+			mw.report(e)
+		}
+	}
+}
+
+// memwatcher watches directories of a MemFS.
+type memwatcher struct {
+	sync.Mutex
+	fs     *MemFS
+	ctrler Controller
+	dirs   map[string][]Id
+	paths  map[Id]string
+}
+
+func (mw *memwatcher) Watch(r *Res) error {
+	path := r.Path()
+	mw.Lock()
+	defer mw.Unlock()
+	if mw.paths == nil {
+		mw.paths = make(map[Id]string)
+	}
+	if _, ok := mw.paths[r.Id]; ok {
+		return fmt.Errorf("duplicate watch")
+	}
+	mw.paths[r.Id] = path
+	mw.dirs[path] = append(mw.dirs[path], r.Id)
+	return nil
+}
+func (mw *memwatcher) Unwatch(id Id) error {
+	mw.Lock()
+	defer mw.Unlock()
+	path, ok := mw.paths[id]
+	if !ok {
+		return nil
+	}
+	delete(mw.paths, id)
+	if ids := without(mw.dirs[path], id); len(ids) > 0 {
+		mw.dirs[path] = ids
+	} else {
+		delete(mw.dirs, path)
+	}
+	return nil
+}
+func (mw *memwatcher) Close() error {
+	m := mw.fs
+	m.Lock()
+	for i, o := range m.watchers {
+		if o == mw {
+			m.watchers = append(m.watchers[:i], m.watchers[i+1:]...)
+			break
+		}
+	}
+	m.Unlock()
+	mw.Lock()
+	defer mw.Unlock()
+	mw.dirs, mw.paths = make(map[string][]Id), nil
+	return nil
+}
+func (mw *memwatcher) report(e memevent) {
+	mw.Lock()
+	from := append([]Id(nil), mw.dirs[e.dir]...)
+	to := append([]Id(nil), mw.dirs[e.to]...)
+	mw.Unlock()
+	var err error
+	switch {
+	case e.op != Move:
+		for _, id := range from {
+			err = mw.ctrler.Control(e.op, id, e.name)
+		}
+	case len(from) > 0 && len(to) > 0:
+		err = mw.ctrler.Move(from[0], e.name, to[0], e.toname)
+	case len(from) > 0:
+		err = mw.ctrler.Control(Delete, from[0], e.name)
+	case len(to) > 0:
+		err = mw.ctrler.Control(Create, to[0], e.toname)
+	}
+	if err != nil {
+		log.Println("memfs:", err)
+	}
+}
+
+func memerr(op, path string, err error) error {
+	return &os.PathError{Op: op, Path: path, Err: err}
+}
+
+type byInfoName []os.FileInfo
+
+func (l byInfoName) Len() int {
+	return len(l)
+}
+func (l byInfoName) Less(i, j int) bool {
+	return l[i].Name() < l[j].Name()
+}
+func (l byInfoName) Swap(i, j int) {
+	l[i], l[j] = l[j], l[i]
+}
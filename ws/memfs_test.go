@@ -0,0 +1,79 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ws
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestMemFS(t *testing.T) {
+	m := NewMemFS()
+	fail := func(msg string, err error) {
+		if err != nil {
+			t.Fatalf("%s: %s", msg, err)
+		}
+	}
+	fail("mkdir", m.MkdirAll("/proj/src"))
+	fail("write", m.WriteFile("/proj/src/a.go", []byte("package a\n")))
+	events := make(testhandler, 20)
+	w := New(Config{CapHint: 100, Handler: events})
+	defer w.Close()
+	if _, err := w.MountFS("/proj", m); err != nil {
+		t.Fatal(err)
+	}
+	for len(events) > 0 {
+		<-events
+	}
+	if r := w.Res(NewId("/proj/src/a.go")); r == nil || r.Size != 10 {
+		t.Fatal("file not read from memfs")
+	}
+	expect := func(msg string, list ...testevent) {
+		for _, want := range list {
+			select {
+			case e := <-events:
+				if e != want {
+					t.Errorf("%s: want %x %q got %x %q", msg, want.Op, want.Path, e.Op, e.Path)
+				}
+			default:
+				t.Fatalf("%s: missing %x %q", msg, want.Op, want.Path)
+			}
+		}
+		if len(events) > 0 {
+			e := <-events
+			t.Errorf("%s: unexpected %x %q", msg, e.Op, e.Path)
+		}
+	}
+	fail("write", m.WriteFile("/proj/b.txt", []byte("b")))
+	expect("write", testevent{Add | Create, "/proj/b.txt"}, testevent{Change | Modify, "/proj/b.txt"})
+	fail("mkdir", m.MkdirAll("/proj/d"))
+	expect("mkdir", testevent{Add | Create, "/proj/d"}, testevent{Change | Create, "/proj/d"})
+	fail("rename", m.Rename("/proj/b.txt", "/proj/d/c.txt"))
+	expect("rename", testevent{Move | Remove, "/proj/b.txt"}, testevent{Move | Add, "/proj/d/c.txt"})
+	r := w.Res(NewId("/proj/d/c.txt"))
+	if r == nil {
+		t.Fatal("renamed file not found")
+	}
+	f, err := r.Open()
+	fail("open", err)
+	data, err := ioutil.ReadAll(f)
+	f.Close()
+	if err != nil || string(data) != "b" {
+		t.Errorf("read %q %v", data, err)
+	}
+	fail("write", m.WriteFile("/proj/d/e.txt", []byte("e")))
+	expect("write", testevent{Add | Create, "/proj/d/e.txt"}, testevent{Change | Modify, "/proj/d/e.txt"})
+	fail("rename replace", m.Rename("/proj/d/e.txt", "/proj/d/c.txt"))
+	expect("rename replace", testevent{Remove | Delete, "/proj/d/e.txt"}, testevent{Change | Modify, "/proj/d/c.txt"})
+	fail("rename dir", m.Rename("/proj/d", "/proj/src/d"))
+	expect("rename dir", testevent{Move | Remove, "/proj/d/c.txt"}, testevent{Move | Remove, "/proj/d"},
+		testevent{Move | Add, "/proj/src/d"}, testevent{Move | Add, "/proj/src/d/c.txt"}, testevent{Move | Change, "/proj/src/d"})
+	fail("remove", m.Remove("/proj/src"))
+	expect("remove", testevent{Remove | Delete, "/proj/src/a.go"}, testevent{Remove | Delete, "/proj/src/d/c.txt"},
+		testevent{Remove | Delete, "/proj/src/d"}, testevent{Remove | Delete, "/proj/src"})
+	if w.Res(NewId("/proj/src")) != nil {
+		t.Error("removed dir still in workspace")
+	}
+}
@@ -0,0 +1,239 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ws
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// echoTimeout is the time to wait for watcher events caused by workspace mutations.
+const echoTimeout = 2 * time.Second
+
+// Create creates a file or directory at path and adds it to the workspace.
+func (w *Ws) Create(path string, dir bool) (*Res, error) {
+	path = filepath.Clean(path)
+	p, err := w.parent(path)
+	if err != nil {
+		return nil, err
+	}
+	if dir {
+		w.expect(p, path, Create)
+		err = os.Mkdir(path, 0777)
+	} else {
+		w.expect(p, path, Create|Modify)
+		err = createFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = (*ctrl)(w).Control(Create, p.Id, filepath.Base(path)); err != nil {
+		return nil, err
+	}
+	return w.resPath(path), nil
+}
+
+// Rename moves the resource at from to the path to. Existing files at to are replaced.
+func (w *Ws) Rename(from, to string) error {
+	from, to = filepath.Clean(from), filepath.Clean(to)
+	if _, err := w.movable(from); err != nil {
+		return err
+	}
+	if err := w.target(to); err != nil {
+		return err
+	}
+	p, err := w.parent(to)
+	if err != nil {
+		return err
+	}
+	if err = os.Rename(from, to); err != nil {
+		return err
+	}
+	return (*ctrl)(w).Move(NewId(filepath.Dir(from)), filepath.Base(from), p.Id, filepath.Base(to))
+}
+
+// Copy copies the file or directory tree at from to the new path to.
+func (w *Ws) Copy(from, to string) error {
+	from, to = filepath.Clean(from), filepath.Clean(to)
+	if _, err := w.disk(from); err != nil {
+		return err
+	}
+	if len(to) > len(from) && to[:len(from)+1] == from+string(filepath.Separator) {
+		return fmt.Errorf("cannot copy %s into itself", from)
+	}
+	if err := w.target(to); err != nil {
+		return err
+	}
+	p, err := w.parent(to)
+	if err != nil {
+		return err
+	}
+	err = filepath.Walk(from, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(to, path[len(from):])
+		// events inside the new directories are not watched
+		top := path == from
+		switch {
+		case fi.IsDir():
+			if top {
+				w.expect(p, dst, Create)
+			}
+			return os.Mkdir(dst, fi.Mode()&os.ModePerm)
+		case fi.Mode()&os.ModeSymlink != 0:
+			if top {
+				w.expect(p, dst, Create)
+			}
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, dst)
+		}
+		if top {
+			w.expect(p, dst, Create|Modify)
+		}
+		return copyFile(dst, path, fi.Mode())
+	})
+	if err != nil {
+		return err
+	}
+	return (*ctrl)(w).Control(Create, p.Id, filepath.Base(to))
+}
+
+// Delete removes the resource at path and all its descendants.
+func (w *Ws) Delete(path string) error {
+	path = filepath.Clean(path)
+	if _, err := w.movable(path); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(path); err != nil {
+		return err
+	}
+	return (*ctrl)(w).Control(Delete, NewId(filepath.Dir(path)), filepath.Base(path))
+}
+
+// parent returns the workspace directory on disk containing path.
+func (w *Ws) parent(path string) (*Res, error) {
+	p, err := w.disk(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+	if p.Dir == nil || p.Flag&FlagLogical != 0 {
+		return nil, fmt.Errorf("%s not in workspace", filepath.Dir(path))
+	}
+	return p, nil
+}
+
+// disk returns the resource at path if it is on disk.
+func (w *Ws) disk(path string) (*Res, error) {
+	r := w.resPath(path)
+	if r == nil {
+		return nil, fmt.Errorf("%s not found", path)
+	}
+	if r.Flag&FlagReadOnly != 0 {
+		return nil, fmt.Errorf("%s is read-only", path)
+	}
+	if r.FS() != Disk {
+		return nil, fmt.Errorf("%s is not on disk", path)
+	}
+	return r, nil
+}
+
+// movable returns the resource at path if it may be renamed or deleted.
+// The workspace root, logical directories and mounts are managed by the workspace.
+func (w *Ws) movable(path string) (*Res, error) {
+	r, err := w.disk(path)
+	if err != nil {
+		return nil, err
+	}
+	if !mounted(r) {
+		return nil, fmt.Errorf("%s is not below a mount", path)
+	}
+	return r, nil
+}
+
+// target returns an error if path is the workspace root, a logical directory or a mount.
+func (w *Ws) target(path string) error {
+	if r := w.resPath(path); r != nil && !mounted(r) {
+		return fmt.Errorf("%s is not below a mount", path)
+	}
+	return nil
+}
+
+// mounted returns whether r is a resource below a mount.
+func mounted(r *Res) bool {
+	return r.Parent != nil && r.Flag&(FlagLogical|FlagMount) == 0
+}
+
+// echo holds the watcher events expected for a workspace mutation.
+type echo struct {
+	ops      Op
+	deadline time.Time
+}
+
+// expect registers create and modify events for path in the watched directory p,
+// that are suppressed when the watcher reports them.
+func (w *Ws) expect(p *Res, path string, ops Op) {
+	w.Lock()
+	defer w.Unlock()
+	if w.watcher == nil || p.Flag&FlagPoll != 0 {
+		return
+	}
+	if w.echo == nil {
+		w.echo = make(map[Id]echo)
+	}
+	now := time.Now()
+	for id, e := range w.echo {
+		if now.After(e.deadline) {
+			delete(w.echo, id)
+		}
+	}
+	w.echo[NewId(path)] = echo{ops, now.Add(echoTimeout)}
+}
+
+// echoed returns whether the event op of r was expected and consumes it.
+// The caller must hold the write lock.
+func (w *ctrl) echoed(op Op, r *Res) bool {
+	e, ok := w.echo[r.Id]
+	if !ok || e.ops&op == 0 {
+		return false
+	}
+	if e.ops &^= op; e.ops == 0 {
+		delete(w.echo, r.Id)
+	} else {
+		w.echo[r.Id] = e
+	}
+	return time.Now().Before(e.deadline)
+}
+
+func createFile(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func copyFile(dst, src string, mode os.FileMode) error {
+	s, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	d, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode&os.ModePerm)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(d, s)
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
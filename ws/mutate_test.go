@@ -0,0 +1,159 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ws
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMutate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wsmutate")
+	if err != nil {
+		t.Fatal("failed to create temp dir")
+	}
+	defer os.RemoveAll(dir)
+	events := make(testhandler, 20)
+	w := New(Config{CapHint: 100, Watcher: NewInotify, Handler: events})
+	defer w.Close()
+	if _, err = w.Mount(dir); err != nil {
+		t.Fatal(err)
+	}
+	<-events
+	<-events
+	expect := func(msg string, list ...testevent) {
+		want := make(map[testevent]bool)
+		for _, e := range list {
+			want[e] = true
+		}
+		for range list {
+			select {
+			case e := <-events:
+				if !want[e] {
+					t.Errorf("%s: unexpected event %x %q", msg, e.Op, e.Path)
+				}
+				delete(want, e)
+			case <-time.After(time.Second):
+				t.Fatalf("%s: timeout expecting %v", msg, want)
+			}
+		}
+	}
+	fail := func(msg string, err error) {
+		if err != nil {
+			t.Fatalf("%s: %s", msg, err)
+		}
+	}
+	r, err := w.Create(dir+"/a", false)
+	fail("create file", err)
+	if r == nil || r.Path() != dir+"/a" {
+		t.Fatal("created file not in workspace")
+	}
+	expect("create file", testevent{Add | Create, dir + "/a"})
+	_, err = w.Create(dir+"/d", true)
+	fail("create dir", err)
+	expect("create dir", testevent{Add | Create, dir + "/d"}, testevent{Change | Create, dir + "/d"})
+	if _, err = w.Create(dir+"/d", true); err == nil {
+		t.Error("expected error creating existing dir")
+	}
+	if _, err = w.Create(dir+"/x/y", false); err == nil {
+		t.Error("expected error creating outside the workspace")
+	}
+
+	fail("rename", w.Rename(dir+"/a", dir+"/d/b"))
+	expect("rename", testevent{Move | Remove, dir + "/a"}, testevent{Move | Add, dir + "/d/b"})
+	if w.Res(NewId(dir+"/d/b")) == nil || w.Res(NewId(dir+"/a")) != nil {
+		t.Error("renamed file not updated")
+	}
+
+	fail("copy", w.Copy(dir+"/d", dir+"/e"))
+	expect("copy",
+		testevent{Add | Create, dir + "/e"},
+		testevent{Add | Create, dir + "/e/b"},
+		testevent{Change | Create, dir + "/e"},
+	)
+	if w.Res(NewId(dir+"/e/b")) == nil {
+		t.Error("copied file not found")
+	}
+	w.RLock()
+	_, echo := w.echo[NewId(dir+"/e/b")]
+	w.RUnlock()
+	if echo {
+		t.Error("expected no echo for files in copied dirs")
+	}
+	if err = w.Copy(dir+"/d", dir+"/d/c"); err == nil {
+		t.Error("expected error copying into itself")
+	}
+
+	fail("delete", w.Delete(dir+"/e"))
+	expect("delete", testevent{Remove | Delete, dir + "/e/b"}, testevent{Remove | Delete, dir + "/e"})
+	if w.Res(NewId(dir+"/e")) != nil {
+		t.Error("deleted dir still in workspace")
+	}
+	if err = w.Delete(dir + "/e"); err == nil {
+		t.Error("expected error deleting missing resource")
+	}
+	select {
+	case e := <-events:
+		t.Errorf("unexpected echo %x %q", e.Op, e.Path)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestMutateMounted(t *testing.T) {
+	base, err := ioutil.TempDir("", "wsmounted")
+	if err != nil {
+		t.Fatal("failed to create temp dir")
+	}
+	defer os.RemoveAll(base)
+	mnt := base + "/a/m"
+	if err = os.MkdirAll(mnt+"/d", 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(mnt+"/f", nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+	w := New(Config{CapHint: 100})
+	defer w.Close()
+	if _, err = w.Mount(mnt); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/", base, base + "/a", mnt} {
+		if _, err := w.movable(path); err == nil {
+			t.Errorf("expected %s not to be movable", path)
+		}
+	}
+	if err := w.Delete(base + "/a"); err == nil {
+		t.Error("expected error deleting a logical directory")
+	}
+	if err := w.Delete(mnt); err == nil {
+		t.Error("expected error deleting a mount")
+	}
+	if err := w.Rename(mnt, base+"/a/n"); err == nil {
+		t.Error("expected error renaming a mount")
+	}
+	if err := w.Rename(base+"/a", base+"/b"); err == nil {
+		t.Error("expected error renaming a logical directory")
+	}
+	if err := w.Rename(mnt+"/f", mnt); err == nil {
+		t.Error("expected error replacing a mount")
+	}
+	if err := w.Copy(mnt+"/d", mnt); err == nil {
+		t.Error("expected error copying onto a mount")
+	}
+	if err := w.Copy(mnt+"/d", "/"); err == nil {
+		t.Error("expected error copying onto the root")
+	}
+	if _, err := os.Stat(mnt + "/f"); err != nil {
+		t.Error(err)
+	}
+	if err := w.Rename(mnt+"/f", mnt+"/d/f"); err != nil {
+		t.Error(err)
+	}
+	if err := w.Delete(mnt + "/d"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,201 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ws
+
+import (
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultPollInterval is used by polling watchers without a configured interval.
+const DefaultPollInterval = 2 * time.Second
+
+type pollentry struct {
+	dir  bool
+	size int64
+	mod  time.Time
+}
+
+type polldir struct {
+	id      Id
+	path    string
+	mount   bool
+	entries map[string]pollentry
+	fs      FS
+}
+
+type pollevent struct {
+	op   Op
+	name string
+}
+
+// poller implements a portable watcher that periodically stats watched directories.
+type poller struct {
+	sync.Mutex
+	dirs   map[Id]*polldir
+	done   chan bool
+	ctrler Controller
+}
+
+// NewPoller returns a watcher constructor for polling watchers with interval.
+func NewPoller(interval time.Duration) func(Controller) (Watcher, error) {
+	return func(ctrler Controller) (Watcher, error) {
+		return newPoller(ctrler, interval), nil
+	}
+}
+
+func newPoller(ctrler Controller, interval time.Duration) *poller {
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+	p := &poller{
+		dirs:   make(map[Id]*polldir),
+		done:   make(chan bool),
+		ctrler: ctrler,
+	}
+	go p.run(interval)
+	return p
+}
+
+// Watch snapshots the directory r and polls it for changes.
+// Watching a resource again replaces its snapshot.
+func (p *poller) Watch(r *Res) error {
+	d := &polldir{id: r.Id, path: r.Path(), mount: r.Flag&FlagMount != 0, fs: r.FS()}
+	entries, err := readentries(d.fs, d.path)
+	if err != nil {
+		return err
+	}
+	d.entries = entries
+	p.Lock()
+	defer p.Unlock()
+	if p.dirs == nil {
+		return os.ErrInvalid
+	}
+	p.dirs[r.Id] = d
+	return nil
+}
+func (p *poller) Unwatch(id Id) error {
+	p.Lock()
+	defer p.Unlock()
+	delete(p.dirs, id)
+	return nil
+}
+func (p *poller) Close() error {
+	p.Lock()
+	defer p.Unlock()
+	if p.dirs == nil {
+		return nil
+	}
+	close(p.done)
+	p.dirs = nil
+	return nil
+}
+func (p *poller) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			p.poll()
+		}
+	}
+}
+func (p *poller) poll() {
+	p.Lock()
+	dirs := make([]*polldir, 0, len(p.dirs))
+	for _, d := range p.dirs {
+		dirs = append(dirs, d)
+	}
+	p.Unlock()
+	for _, d := range dirs {
+		entries, err := readentries(d.fs, d.path)
+		p.Lock()
+		if p.dirs == nil {
+			p.Unlock()
+			return
+		}
+		if p.dirs[d.id] != d {
+			// replaced while reading
+			p.Unlock()
+			continue
+		}
+		if err != nil {
+			// the watcher only reports the mount itself as deleted,
+			// other directories are reported by their parents
+			delete(p.dirs, d.id)
+			p.Unlock()
+			if d.mount && os.IsNotExist(err) {
+				p.control(Delete, d.id, "")
+			}
+			continue
+		}
+		events := d.diff(entries)
+		d.entries = entries
+		p.Unlock()
+		for _, e := range events {
+			p.control(e.op, d.id, e.name)
+		}
+	}
+}
+func (p *poller) control(op Op, id Id, name string) {
+	if err := p.ctrler.Control(op, id, name); err != nil {
+		log.Println("poller:", err)
+	}
+}
+
+// diff returns delete, create and modify events for entries sorted by name.
+func (d *polldir) diff(entries map[string]pollentry) []pollevent {
+	var dels, adds, mods []pollevent
+	for name, old := range d.entries {
+		e, ok := entries[name]
+		if !ok || e.dir != old.dir {
+			dels = append(dels, pollevent{Delete, name})
+		}
+	}
+	for name, e := range entries {
+		old, ok := d.entries[name]
+		switch {
+		case !ok || e.dir != old.dir:
+			adds = append(adds, pollevent{Create, name})
+		case !e.dir && (e.size != old.size || !e.mod.Equal(old.mod)):
+			mods = append(mods, pollevent{Modify, name})
+		}
+	}
+	events := make([]pollevent, 0, len(dels)+len(adds)+len(mods))
+	for _, l := range [][]pollevent{dels, adds, mods} {
+		sort.Sort(byEventName(l))
+		events = append(events, l...)
+	}
+	return events
+}
+
+func readentries(fs FS, path string) (map[string]pollentry, error) {
+	list, err := fs.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	entries := make(map[string]pollentry, len(list))
+	for _, fi := range list {
+		entries[fi.Name()] = pollentry{fi.IsDir(), fi.Size(), fi.ModTime()}
+	}
+	return entries, nil
+}
+
+type byEventName []pollevent
+
+func (l byEventName) Len() int {
+	return len(l)
+}
+func (l byEventName) Less(i, j int) bool {
+	return l[i].name < l[j].name
+}
+func (l byEventName) Swap(i, j int) {
+	l[i], l[j] = l[j], l[i]
+}
@@ -0,0 +1,297 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ws
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Match is a query result.
+type Match struct {
+	Id    Id
+	Path  string
+	IsDir bool
+	// Score ranks fuzzy matches, higher is better.
+	Score int
+}
+
+// nameEntry is the query index entry of a resource.
+type nameEntry struct {
+	path string
+	// rel is the offset of the path relative to its mount
+	rel int
+	dir bool
+}
+
+func (e *nameEntry) name() string {
+	return e.path[strings.LastIndexByte(e.path, filepath.Separator)+1:]
+}
+
+// chars returns the unique case folded bytes of the name and relative path.
+func (e *nameEntry) chars() []byte {
+	var seen [256]bool
+	var list []byte
+	for _, s := range []string{e.name(), e.path[e.rel:]} {
+		for i := 0; i < len(s); i++ {
+			if c := fold(s[i]); !seen[c] {
+				seen[c] = true
+				list = append(list, c)
+			}
+		}
+	}
+	return list
+}
+
+// nameIndex holds the query index entries by id. Entries are also listed by the
+// trigrams of their names for globs and by the bytes of their names and relative
+// paths for fuzzy queries.
+type nameIndex struct {
+	all   map[Id]*nameEntry
+	grams map[uint32]map[Id]*nameEntry
+	chars map[byte]map[Id]*nameEntry
+}
+
+func newNameIndex(capHint uint) *nameIndex {
+	return &nameIndex{
+		all:   make(map[Id]*nameEntry, capHint),
+		grams: make(map[uint32]map[Id]*nameEntry),
+		chars: make(map[byte]map[Id]*nameEntry),
+	}
+}
+
+func (n *nameIndex) add(id Id, e *nameEntry) {
+	n.del(id)
+	n.all[id] = e
+	for _, g := range trigrams([]byte(e.name())) {
+		set := n.grams[g]
+		if set == nil {
+			set = make(map[Id]*nameEntry)
+			n.grams[g] = set
+		}
+		set[id] = e
+	}
+	for _, c := range e.chars() {
+		set := n.chars[c]
+		if set == nil {
+			set = make(map[Id]*nameEntry)
+			n.chars[c] = set
+		}
+		set[id] = e
+	}
+}
+
+func (n *nameIndex) del(id Id) {
+	e := n.all[id]
+	if e == nil {
+		return
+	}
+	delete(n.all, id)
+	for _, g := range trigrams([]byte(e.name())) {
+		if delete(n.grams[g], id); len(n.grams[g]) == 0 {
+			delete(n.grams, g)
+		}
+	}
+	for _, c := range e.chars() {
+		if delete(n.chars[c], id); len(n.chars[c]) == 0 {
+			delete(n.chars, c)
+		}
+	}
+}
+
+// named returns the entries that may have names matching the glob part.
+// These are the entries with the least common trigram of the literals of part,
+// or all entries if part has no literal of at least three bytes.
+func (n *nameIndex) named(part string) map[Id]*nameEntry {
+	res := n.all
+	for _, lit := range literals(part) {
+		for _, g := range trigrams([]byte(lit)) {
+			if set := n.grams[g]; len(set) < len(res) {
+				res = set
+			}
+		}
+	}
+	return res
+}
+
+// containing returns the entries that may fuzzy match query.
+// These are the entries with the least common byte of query.
+func (n *nameIndex) containing(query string) map[Id]*nameEntry {
+	res := n.all
+	for i := 0; i < len(query); i++ {
+		if query[i] >= utf8.RuneSelf {
+			continue
+		}
+		if set := n.chars[fold(query[i])]; len(set) < len(res) {
+			res = set
+		}
+	}
+	return res
+}
+
+// literals returns the literal runs of the glob pattern part.
+func literals(part string) []string {
+	var list []string
+	start := 0
+	for i := 0; i < len(part); i++ {
+		switch part[i] {
+		case '*', '?', '[', '\\':
+			if i > start {
+				list = append(list, part[start:i])
+			}
+			if part[i] == '[' {
+				if j := strings.IndexByte(part[i:], ']'); j > 0 {
+					i += j
+				}
+			} else if part[i] == '\\' {
+				i++
+			}
+			start = i + 1
+		}
+	}
+	if start < len(part) {
+		list = append(list, part[start:])
+	}
+	return list
+}
+
+// index adds r to the query index. The caller must hold the write lock.
+func (w *Ws) index(r *Res) {
+	if r.Flag&(FlagLogical|FlagIgnore) != 0 {
+		w.names.del(r.Id)
+		return
+	}
+	e := &nameEntry{path: r.path(false), dir: r.Flag&FlagDir != 0}
+	e.rel = len(e.path)
+	for a := r; a != nil && a.Flag&FlagLogical == 0; a = a.Parent {
+		if a.Flag&FlagMount != 0 {
+			if a != r {
+				e.rel = len(a.path(false)) + 1
+			}
+			break
+		}
+	}
+	w.names.add(r.Id, e)
+}
+
+// Glob returns the resources matching the gitignore style pattern, shortest paths first.
+// Patterns starting with a slash match absolute paths, patterns with an inner slash
+// match paths relative to the mount, others match names at any depth.
+// Only entries with names containing the literals of the last pattern part are matched.
+func (w *Ws) Glob(pattern string) []Match {
+	abs := strings.HasPrefix(pattern, "/")
+	p, ok := parsePattern(pattern)
+	if !ok {
+		return nil
+	}
+	sep := string(filepath.Separator)
+	var res []Match
+	w.RLock()
+	cands := w.names.all
+	if last := p.parts[len(p.parts)-1]; last != "**" {
+		cands = w.names.named(last)
+	}
+	for id, e := range cands {
+		if p.dir && !e.dir {
+			continue
+		}
+		path := e.path[e.rel:]
+		if abs {
+			path = strings.TrimPrefix(e.path, sep)
+		}
+		if path != "" && match(p.parts, strings.Split(path, sep)) {
+			res = append(res, Match{Id: id, Path: e.path, IsDir: e.dir})
+		}
+	}
+	w.RUnlock()
+	sort.Sort(byScoreAndPath(res))
+	return res
+}
+
+// Find returns at most max resources fuzzy matching query ranked by score.
+// Query characters must appear in order in the name or the path relative to the mount.
+// Name matches, consecutive characters and characters at word starts rank higher.
+// Only entries containing the least common byte of the query are matched.
+func (w *Ws) Find(query string, max int) []Match {
+	if query == "" || max <= 0 {
+		return nil
+	}
+	var res []Match
+	w.RLock()
+	for id, e := range w.names.containing(query) {
+		score, ok := fuzzy(query, e.name())
+		if ok {
+			score += 100
+		} else if score, ok = fuzzy(query, e.path[e.rel:]); !ok {
+			continue
+		}
+		res = append(res, Match{id, e.path, e.dir, score})
+	}
+	w.RUnlock()
+	sort.Sort(byScoreAndPath(res))
+	if len(res) > max {
+		res = res[:max]
+	}
+	return res
+}
+
+// fuzzy returns the score of the case insensitive subsequence match of query in s.
+func fuzzy(query, s string) (score int, ok bool) {
+	var prev rune
+	consecutive := false
+	for _, c := range s {
+		if query == "" {
+			break
+		}
+		q, n := utf8.DecodeRuneInString(query)
+		if unicode.ToLower(c) != unicode.ToLower(q) {
+			consecutive = false
+			prev = c
+			continue
+		}
+		score++
+		if c == q {
+			score++
+		}
+		if consecutive {
+			score += 4
+		}
+		switch {
+		case prev == 0, prev == filepath.Separator, strings.ContainsRune("/._- ", prev):
+			score += 8
+		case unicode.IsLower(prev) && unicode.IsUpper(c):
+			score += 6
+		}
+		consecutive = true
+		query = query[n:]
+		prev = c
+	}
+	if query != "" {
+		return 0, false
+	}
+	// prefer shorter candidates
+	return score*16 - len(s)/4, true
+}
+
+type byScoreAndPath []Match
+
+func (l byScoreAndPath) Len() int {
+	return len(l)
+}
+func (l byScoreAndPath) Less(i, j int) bool {
+	if l[i].Score != l[j].Score {
+		return l[i].Score > l[j].Score
+	}
+	if len(l[i].Path) != len(l[j].Path) {
+		return len(l[i].Path) < len(l[j].Path)
+	}
+	return l[i].Path < l[j].Path
+}
+func (l byScoreAndPath) Swap(i, j int) {
+	l[i], l[j] = l[j], l[i]
+}
@@ -0,0 +1,109 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ws
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestQuery(t *testing.T) {
+	m := NewMemFS()
+	dir := "/q"
+	for _, name := range []string{
+		"/main.go",
+		"/src/foo_bar.go",
+		"/src/fb.txt",
+		"/doc/readme.md",
+		"/doc/ignored.o",
+	} {
+		path := dir + name
+		m.MkdirAll(path[:strings.LastIndex(path, "/")])
+		m.WriteFile(path, nil)
+	}
+	w := New(Config{CapHint: 100, Filter: NewIgnore("", []string{"*.o"})})
+	defer w.Close()
+	if _, err := w.MountFS(dir, m); err != nil {
+		t.Fatal(err)
+	}
+	paths := func(list []Match) string {
+		res := make([]string, 0, len(list))
+		for _, m := range list {
+			res = append(res, strings.TrimPrefix(m.Path, dir))
+		}
+		return strings.Join(res, " ")
+	}
+	globs := []struct {
+		pattern string
+		expect  string
+	}{
+		{"*.go", "/main.go /src/foo_bar.go"},
+		{"src/*", "/src/fb.txt /src/foo_bar.go"},
+		{"doc/", "/doc"},
+		{"*.o", ""},
+		{dir + "/*.go", "/main.go"},
+		{"*_BAR*", ""},
+		{"*_bar*", "/src/foo_bar.go"},
+		{"f?.txt", "/src/fb.txt"},
+	}
+	for _, test := range globs {
+		if got := paths(w.Glob(test.pattern)); got != test.expect {
+			t.Errorf("glob %q expected %q got %q", test.pattern, test.expect, got)
+		}
+	}
+	finds := []struct {
+		query  string
+		max    int
+		expect string
+	}{
+		{"fb", 10, "/src/foo_bar.go /src/fb.txt"},
+		{"FB", 1, "/src/foo_bar.go"},
+		{"readme", 10, "/doc/readme.md"},
+		{"srcfbt", 10, "/src/fb.txt"},
+		{"xyz", 10, ""},
+	}
+	for _, test := range finds {
+		if got := paths(w.Find(test.query, test.max)); got != test.expect {
+			t.Errorf("find %q expected %q got %q", test.query, test.expect, got)
+		}
+	}
+	if err := m.Remove(dir + "/src/fb.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if got := paths(w.Find("fb", 10)); got != "/src/foo_bar.go" {
+		t.Errorf("removed resource found %q", got)
+	}
+	if id := NewId(dir + "/src/fb.txt"); w.names.chars['x'][id] != nil || w.names.grams[trigrams([]byte("txt"))[0]][id] != nil {
+		t.Errorf("removed resource still listed")
+	}
+	if err := m.WriteFile(dir+"/src/new.o", nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := paths(w.Glob("*.o")); got != "" {
+		t.Errorf("ignored resource globbed %q", got)
+	}
+	if got := paths(w.Find("new", 10)); got != "" {
+		t.Errorf("ignored resource found %q", got)
+	}
+}
+
+func TestLiterals(t *testing.T) {
+	tests := []struct {
+		part   string
+		expect string
+	}{
+		{"*.go", ".go"},
+		{"foo", "foo"},
+		{"a*b?c", "a b c"},
+		{"x[a-z]*yz", "x yz"},
+		{"ab\\*cd", "ab cd"},
+		{"*", ""},
+	}
+	for _, test := range tests {
+		if got := strings.Join(literals(test.part), " "); got != test.expect {
+			t.Errorf("literals %q expected %q got %q", test.part, test.expect, got)
+		}
+	}
+}
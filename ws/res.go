@@ -8,8 +8,11 @@ import (
 	"fmt"
 	"hash/fnv"
 	"os"
+	"path/filepath"
 	"sort"
+	"strings"
 	"sync"
+	"time"
 )
 
 const (
@@ -17,22 +20,37 @@ const (
 	FlagLogical
 	FlagMount
 	FlagIgnore
+	FlagPoll
+	FlagLink
+	FlagReadOnly
 )
 
 // Skip is returned by walk visitors to prevent visiting children of the resource in context.
 var Skip = fmt.Errorf("skip")
 
 // Id identifies a workspace resource uniquely.
-// Having a fnv32 hash collision is considered a user error.
-type Id uint32
+// Ids are 64 bit fnv-1a hashes, workspaces report collisions when resources are added.
+type Id uint64
 
 // Creates a workspace id for path.
 // Path must be absolute and clean (sans trailing slash).
 func NewId(path string) Id {
-	h := fnv.New32()
+	h := fnv.New64a()
 	h.Write([]byte(path))
-	return Id(h.Sum32())
+	return Id(h.Sum64())
 }
+
+// without returns a new list of ids without id.
+func without(ids []Id, id Id) []Id {
+	res := make([]Id, 0, len(ids))
+	for _, i := range ids {
+		if i != id {
+			res = append(res, i)
+		}
+	}
+	return res
+}
+
 func (id Id) MarshalJSON() ([]byte, error) {
 	str := fmt.Sprintf(`"%X"`, id)
 	return []byte(str), nil
@@ -46,6 +64,8 @@ func (id *Id) UnmarshalJSON(data []byte) error {
 type Dir struct {
 	Path     string
 	Children []*Res
+	// fs is the filesystem of the mount.
+	fs FS
 }
 
 // Res describes a workspace resource.
@@ -56,6 +76,15 @@ type Res struct {
 	Flag   uint64
 	Parent *Res
 	*Dir
+
+	// Size, Mode and ModTime describe the resource when last read or modified.
+	Size    int64
+	Mode    os.FileMode
+	ModTime time.Time
+	// Link holds the target of symbolic links.
+	Link string
+	// sum holds the content hash of files, it is computed lazily.
+	sum *sum
 }
 
 func (r *Res) path(lock bool) string {
@@ -69,11 +98,14 @@ func (r *Res) path(lock bool) string {
 	if r.Dir != nil {
 		return r.Dir.Path
 	}
-	p := r.Parent.path(lock)
-	if len(p) < 2 {
-		return p + r.Name
+	return join(r.Parent.path(lock), r.Name)
+}
+
+func join(dir, name string) string {
+	if len(dir) < 2 {
+		return dir + name
 	}
-	return p + string(os.PathSeparator) + r.Name
+	return dir + string(os.PathSeparator) + name
 }
 
 // Path returns the full resource path.
@@ -81,24 +113,86 @@ func (r *Res) Path() string {
 	return r.path(true)
 }
 
-func newChild(pa *Res, name string, isdir, stat bool) (*Res, error) {
-	r := &Res{Name: name, Parent: pa}
+// newChild returns a new child resource of pa described by fi.
+// The file info is read if fi is nil. Links are followed according to links.
+func newChild(pa *Res, name string, fi os.FileInfo, links LinkPolicy) (*Res, error) {
+	r := &Res{Name: name, Parent: pa, Flag: pa.Flag & (FlagPoll | FlagReadOnly)}
 	path := r.path(false)
 	r.Id = NewId(path)
-	if stat {
-		fi, err := os.Stat(path)
-		if err != nil {
+	fs := pa.fs(false)
+	if fi == nil {
+		var err error
+		if fi, err = fs.Lstat(path); err != nil {
 			return nil, err
 		}
-		isdir = fi.IsDir()
+	}
+	setstat(r, fs, path, fi)
+	isdir := fi.IsDir()
+	if fi.Mode()&os.ModeSymlink != 0 {
+		r.Flag |= FlagLink
+		// only links on disk are followed
+		isdir = links == LinkFollow && fs == Disk && follow(pa, path)
 	}
 	if isdir {
 		r.Flag |= FlagDir
-		r.Dir = &Dir{Path: path}
+		r.Dir = &Dir{Path: path, fs: fs}
 	}
 	return r, nil
 }
 
+// follow returns whether the link at path points to a directory that contains
+// neither pa nor any of its ancestors within the mount.
+func follow(pa *Res, path string) bool {
+	fi, err := os.Stat(path)
+	if err != nil || !fi.IsDir() {
+		return false
+	}
+	target, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return false
+	}
+	for a := pa; a != nil && a.Flag&FlagLogical == 0; a = a.Parent {
+		real, err := filepath.EvalSymlinks(a.path(false))
+		if err != nil || within(real, target) {
+			return false
+		}
+		if a.Flag&FlagMount != 0 {
+			break
+		}
+	}
+	return true
+}
+
+// within returns whether path is dir or inside dir.
+func within(path, dir string) bool {
+	if !strings.HasPrefix(path, dir) {
+		return false
+	}
+	return len(path) == len(dir) || dir[len(dir)-1] == os.PathSeparator || path[len(dir)] == os.PathSeparator
+}
+
+// setstat sets the resource metadata from fi.
+func setstat(r *Res, fs FS, path string, fi os.FileInfo) {
+	r.Size, r.Mode, r.ModTime = fi.Size(), fi.Mode(), fi.ModTime()
+	r.Link = ""
+	if fi.Mode()&os.ModeSymlink != 0 {
+		r.Link, _ = fs.Readlink(path)
+	}
+}
+
+// restat reads and sets the resource metadata.
+func restat(r *Res) error {
+	r.Lock()
+	defer r.Unlock()
+	path, fs := r.path(false), r.fs(false)
+	fi, err := fs.Lstat(path)
+	if err != nil {
+		return err
+	}
+	setstat(r, fs, path, fi)
+	return nil
+}
+
 type byTypeAndName []*Res
 
 func (l byTypeAndName) Len() int {
@@ -122,7 +216,7 @@ func insert(l []*Res, r *Res) []*Res {
 		return less(r, l[i])
 	})
 	if i < len(l) {
-		if i > 0 && l[i-1].Id == r.Id {
+		if i > 0 && l[i-1].Name == r.Name {
 			l[i-1] = r
 			return l
 		}
@@ -134,7 +228,7 @@ func remove(l []*Res, r *Res) []*Res {
 	i := sort.Search(len(l), func(i int) bool {
 		return less(r, l[i])
 	})
-	if i > 0 && l[i-1].Id == r.Id {
+	if i > 0 && l[i-1].Name == r.Name {
 		return append(l[:i-1], l[i:]...)
 	}
 	return l
@@ -0,0 +1,347 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ws
+
+import (
+	"bytes"
+	"path/filepath"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// MaxIndexSize is the maximum size of files added to the search index.
+const MaxIndexSize = 1 << 20
+
+// Line is a search result.
+type Line struct {
+	Id   Id
+	Path string
+	// Line is the 1-based line number.
+	Line int
+	Text string
+}
+
+// indexfile holds the sorted case folded trigrams of a text file.
+type indexfile struct {
+	path  string
+	fs    FS
+	grams []uint32
+}
+
+// Index is an incremental trigram index over text files for regular expression search.
+// It implements a Handler that should receive events of non-ignored resources.
+// Files are read and indexed in the background.
+type Index struct {
+	sync.RWMutex
+	files map[Id]*indexfile
+	// posts lists the files by trigram
+	posts   map[uint32]map[Id]*indexfile
+	dirs    []string
+	pending map[Id]*Res
+	notify  chan bool
+	done    chan bool
+}
+
+// NewIndex returns a new empty index.
+func NewIndex() *Index {
+	x := &Index{
+		files:   make(map[Id]*indexfile),
+		posts:   make(map[uint32]map[Id]*indexfile),
+		pending: make(map[Id]*Res),
+		notify:  make(chan bool, 1),
+		done:    make(chan bool),
+	}
+	go x.run()
+	return x
+}
+
+// Scope restricts indexing to files below the directories dirs.
+// All files are indexed if dirs is empty. Indexed files are kept.
+func (x *Index) Scope(dirs ...string) {
+	x.Lock()
+	defer x.Unlock()
+	x.dirs = dirs
+}
+
+// Handle queues added and modified files for indexing and removes removed files.
+func (x *Index) Handle(op Op, r *Res) {
+	if r.Flag&(FlagDir|FlagIgnore) != 0 {
+		return
+	}
+	if op&(Add|Modify|Remove) == 0 {
+		return
+	}
+	id := r.Id
+	x.Lock()
+	if op&Remove != 0 {
+		x.del(id)
+		// queue removed files to discard them if they are being indexed
+		r = nil
+	} else if !x.inscope(r.path(false)) {
+		x.Unlock()
+		return
+	}
+	if x.pending != nil {
+		x.pending[id] = r
+	}
+	x.Unlock()
+	select {
+	case x.notify <- true:
+	default:
+	}
+}
+
+// Close stops indexing.
+func (x *Index) Close() error {
+	x.Lock()
+	defer x.Unlock()
+	if x.pending == nil {
+		return nil
+	}
+	close(x.done)
+	x.pending = nil
+	return nil
+}
+
+func (x *Index) run() {
+	for {
+		select {
+		case <-x.done:
+			return
+		case <-x.notify:
+		}
+		x.Lock()
+		pending := x.pending
+		if pending != nil {
+			x.pending = make(map[Id]*Res)
+		}
+		x.Unlock()
+		for id, r := range pending {
+			if r != nil {
+				x.index(id, r)
+			}
+		}
+	}
+}
+
+// index reads and indexes the file r with id.
+func (x *Index) index(id Id, r *Res) {
+	path, fs := r.Path(), r.FS()
+	data, err := readAll(fs, path)
+	var f *indexfile
+	if err == nil && len(data) <= MaxIndexSize && istext(data) {
+		f = &indexfile{path, fs, trigrams(data)}
+	}
+	x.Lock()
+	defer x.Unlock()
+	if _, queued := x.pending[id]; queued {
+		// a newer event will index the file
+		return
+	}
+	x.del(id)
+	if f != nil {
+		x.put(id, f)
+	}
+}
+
+// inscope returns whether path is below the scope dirs. The caller holds the lock.
+func (x *Index) inscope(path string) bool {
+	if len(x.dirs) == 0 {
+		return true
+	}
+	for _, dir := range x.dirs {
+		if strings.HasPrefix(path, dir) && (len(path) == len(dir) || path[len(dir)] == filepath.Separator) {
+			return true
+		}
+	}
+	return false
+}
+
+// put adds the file f with id to the index. The caller holds the lock.
+func (x *Index) put(id Id, f *indexfile) {
+	x.files[id] = f
+	for _, g := range f.grams {
+		post := x.posts[g]
+		if post == nil {
+			post = make(map[Id]*indexfile)
+			x.posts[g] = post
+		}
+		post[id] = f
+	}
+}
+
+// del removes the file with id from the index. The caller holds the lock.
+func (x *Index) del(id Id) {
+	f := x.files[id]
+	if f == nil {
+		return
+	}
+	delete(x.files, id)
+	for _, g := range f.grams {
+		if delete(x.posts[g], id); len(x.posts[g]) == 0 {
+			delete(x.posts, g)
+		}
+	}
+}
+
+// candidates returns the files listed for the least common required trigram.
+// The caller holds the read lock.
+func (x *Index) candidates(req []uint32) map[Id]*indexfile {
+	if len(req) == 0 {
+		return x.files
+	}
+	var res map[Id]*indexfile
+	for i, g := range req {
+		if post := x.posts[g]; i == 0 || len(post) < len(res) {
+			res = post
+		}
+	}
+	return res
+}
+
+// Search returns at most max lines of indexed files matching the regular expression expr.
+// Results are sorted by path and line.
+func (x *Index) Search(expr string, max int) ([]Line, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	req, err := required(expr)
+	if err != nil {
+		return nil, err
+	}
+	var cands []candidate
+	x.RLock()
+	for id, f := range x.candidates(req) {
+		if containsAll(f.grams, req) {
+			cands = append(cands, candidate{id, f.path, f.fs})
+		}
+	}
+	x.RUnlock()
+	sort.Sort(byCandPath(cands))
+	var res []Line
+	for _, c := range cands {
+		data, err := readAll(c.fs, c.path)
+		if err != nil {
+			continue
+		}
+		for n, line := range bytes.Split(data, []byte("\n")) {
+			if len(res) >= max {
+				return res, nil
+			}
+			if re.Match(line) {
+				res = append(res, Line{c.id, c.path, n + 1, string(bytes.TrimRight(line, "\r"))})
+			}
+		}
+	}
+	return res, nil
+}
+
+// istext returns whether data has no NUL byte in the first 8000 bytes.
+func istext(data []byte) bool {
+	if len(data) > 8000 {
+		data = data[:8000]
+	}
+	return bytes.IndexByte(data, 0) < 0
+}
+
+func fold(b byte) byte {
+	if 'A' <= b && b <= 'Z' {
+		return b + 'a' - 'A'
+	}
+	return b
+}
+
+// trigrams returns the sorted unique case folded trigrams of data.
+func trigrams(data []byte) []uint32 {
+	set := make(map[uint32]bool)
+	for i := 0; i+3 <= len(data); i++ {
+		set[uint32(fold(data[i]))<<16|uint32(fold(data[i+1]))<<8|uint32(fold(data[i+2]))] = true
+	}
+	list := make([]uint32, 0, len(set))
+	for g := range set {
+		list = append(list, g)
+	}
+	sort.Sort(gramList(list))
+	return list
+}
+
+// required returns trigrams that every file matching expr must contain.
+// Only literals concatenated at the top level of the expression are considered.
+func required(expr string) ([]uint32, error) {
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return nil, err
+	}
+	re = re.Simplify()
+	for re.Op == syntax.OpCapture {
+		re = re.Sub[0]
+	}
+	subs := []*syntax.Regexp{re}
+	if re.Op == syntax.OpConcat {
+		subs = re.Sub
+	}
+	var req []uint32
+	for _, sub := range subs {
+		if sub.Op != syntax.OpLiteral || sub.Flags&syntax.FoldCase != 0 && !isascii(sub.Rune) {
+			continue
+		}
+		req = append(req, trigrams([]byte(string(sub.Rune)))...)
+	}
+	return req, nil
+}
+
+func containsAll(grams, req []uint32) bool {
+	for _, g := range req {
+		i := sort.Search(len(grams), func(i int) bool { return grams[i] >= g })
+		if i == len(grams) || grams[i] != g {
+			return false
+		}
+	}
+	return true
+}
+
+func isascii(rs []rune) bool {
+	for _, r := range rs {
+		if r >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+type candidate struct {
+	id   Id
+	path string
+	fs   FS
+}
+
+type byCandPath []candidate
+
+func (l byCandPath) Len() int {
+	return len(l)
+}
+func (l byCandPath) Less(i, j int) bool {
+	return l[i].path < l[j].path
+}
+func (l byCandPath) Swap(i, j int) {
+	l[i], l[j] = l[j], l[i]
+}
+
+type gramList []uint32
+
+func (l gramList) Len() int {
+	return len(l)
+}
+func (l gramList) Less(i, j int) bool {
+	return l[i] < l[j]
+}
+func (l gramList) Swap(i, j int) {
+	l[i], l[j] = l[j], l[i]
+}
@@ -0,0 +1,133 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ws
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRequired(t *testing.T) {
+	tests := []struct {
+		expr  string
+		grams int
+	}{
+		{"abc", 1},
+		{"abcd", 2},
+		{"(abcd)", 2},
+		{"ab.*cde", 1},
+		{"(?i)ABC", 1},
+		{"abc|def", 0},
+		{"a[bc]d", 0},
+		{"(?i)äbc", 0},
+	}
+	for _, test := range tests {
+		req, err := required(test.expr)
+		if err != nil {
+			t.Errorf("expr %q: %s", test.expr, err)
+			continue
+		}
+		if len(req) != test.grams {
+			t.Errorf("expr %q expected %d trigrams got %d", test.expr, test.grams, len(req))
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	m := NewMemFS()
+	dir := "/x"
+	m.MkdirAll(dir)
+	files := map[string]string{
+		"/a.go":  "package a\n\nfunc Hello() {}\n",
+		"/b.txt": "hello world\r\nbye\n",
+		"/c.bin": "hello\x00binary",
+	}
+	for name, data := range files {
+		m.WriteFile(dir+name, []byte(data))
+	}
+	x := NewIndex()
+	defer x.Close()
+	w := New(Config{CapHint: 100, Handler: x})
+	defer w.Close()
+	if _, err := w.MountFS(dir, m); err != nil {
+		t.Fatal(err)
+	}
+	search := func(expr string, n int) []Line {
+		var res []Line
+		var err error
+		for i := 0; i < 100; i++ {
+			if res, err = x.Search(expr, 10); err != nil {
+				t.Fatal(err)
+			}
+			if len(res) == n {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return res
+	}
+	res := search("(?i)hello", 2)
+	if len(res) != 2 {
+		t.Fatalf("expected 2 results got %v", res)
+	}
+	if l := res[0]; l.Path != dir+"/a.go" || l.Line != 3 || l.Text != "func Hello() {}" {
+		t.Errorf("unexpected result %v", l)
+	}
+	if l := res[1]; l.Path != dir+"/b.txt" || l.Line != 1 || l.Text != "hello world" {
+		t.Errorf("unexpected result %v", l)
+	}
+	if _, err := x.Search("(", 10); err == nil {
+		t.Error("expected error for invalid expression")
+	}
+	if err := m.WriteFile(dir+"/b.txt", []byte("goodbye\n")); err != nil {
+		t.Fatal(err)
+	}
+	if res = search("goodbye", 1); len(res) != 1 || res[0].Text != "goodbye" {
+		t.Errorf("modified file not reindexed %v", res)
+	}
+	if err := m.Remove(dir + "/a.go"); err != nil {
+		t.Fatal(err)
+	}
+	if res, _ = x.Search("Hello", 10); len(res) != 0 {
+		t.Errorf("deleted file found %v", res)
+	}
+}
+
+func TestSearchScope(t *testing.T) {
+	m := NewMemFS()
+	m.MkdirAll("/m/work")
+	m.MkdirAll("/m/other")
+	m.WriteFile("/m/work/a.txt", []byte("hello work\n"))
+	m.WriteFile("/m/other/b.txt", []byte("hello other\n"))
+	x := NewIndex()
+	defer x.Close()
+	x.Scope("/m/work")
+	w := New(Config{CapHint: 100, Handler: x})
+	defer w.Close()
+	if _, err := w.MountFS("/m", m); err != nil {
+		t.Fatal(err)
+	}
+	x.RLock()
+	_, queued := x.pending[NewId("/m/other/b.txt")]
+	x.RUnlock()
+	if queued {
+		t.Error("file outside the scope queued")
+	}
+	var res []Line
+	for i := 0; i < 100 && len(res) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		res, _ = x.Search("hello", 10)
+	}
+	if len(res) != 1 || res[0].Path != "/m/work/a.txt" {
+		t.Fatalf("expected result in work dir got %v", res)
+	}
+	m.Remove("/m/work/a.txt")
+	x.RLock()
+	files, posts := len(x.files), len(x.posts)
+	x.RUnlock()
+	if files != 0 || posts != 0 {
+		t.Errorf("removed file still indexed with %d trigrams", posts)
+	}
+}
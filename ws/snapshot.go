@@ -0,0 +1,151 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ws
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// snapshotVersion is incremented when the snapshot format changes.
+const snapshotVersion = 1
+
+// Snapshot holds mounted resource trees read from a cache.
+// Mounting a path contained in the workspace snapshot uses the cached tree
+// instead of reading it and validates changed directories in the background.
+type Snapshot struct {
+	mounts map[string]*snapres
+}
+
+type snapfile struct {
+	Version int
+	Mounts  []snapres
+}
+
+// snapres holds the cached names, flags and metadata of a resource.
+type snapres struct {
+	Name     string
+	Flag     uint64
+	Size     int64
+	Mode     os.FileMode
+	ModTime  time.Time
+	Link     string
+	Read     bool
+	Children []snapres
+}
+
+// ReadSnapshot reads a snapshot written by WriteSnapshot.
+func ReadSnapshot(rd io.Reader) (*Snapshot, error) {
+	var f snapfile
+	if err := gob.NewDecoder(rd).Decode(&f); err != nil {
+		return nil, err
+	}
+	if f.Version != snapshotVersion {
+		return nil, fmt.Errorf("snapshot version %d not supported", f.Version)
+	}
+	s := &Snapshot{make(map[string]*snapres, len(f.Mounts))}
+	for i := range f.Mounts {
+		s.mounts[f.Mounts[i].Name] = &f.Mounts[i]
+	}
+	return s, nil
+}
+
+// WriteSnapshot writes the mounted resource trees to wr.
+func (w *Ws) WriteSnapshot(wr io.Writer) error {
+	f := snapfile{Version: snapshotVersion}
+	w.RLock()
+	walk(w.root.Children, func(r *Res) error {
+		if r.Flag&FlagMount == 0 {
+			return nil
+		}
+		r.Lock()
+		path := ""
+		if r.Dir != nil && r.Dir.fs == Disk {
+			path = r.Dir.Path
+		}
+		r.Unlock()
+		if path != "" {
+			m := newSnapres(r)
+			m.Name = path
+			f.Mounts = append(f.Mounts, m)
+		}
+		return Skip
+	})
+	w.RUnlock()
+	return gob.NewEncoder(wr).Encode(&f)
+}
+
+func (s *Snapshot) find(path string) *snapres {
+	if s == nil {
+		return nil
+	}
+	return s.mounts[path]
+}
+
+// newSnapres returns the snapshot of r and its children.
+func newSnapres(r *Res) snapres {
+	r.Lock()
+	s := snapres{
+		Name:    r.Name,
+		Flag:    r.Flag & (FlagDir | FlagLink),
+		Size:    r.Size,
+		Mode:    r.Mode,
+		ModTime: r.ModTime,
+		Link:    r.Link,
+		Read:    r.Flag&(FlagDir|FlagIgnore) == FlagDir,
+	}
+	var children []*Res
+	if s.Read {
+		children = r.Children
+	}
+	r.Unlock()
+	if children == nil {
+		return s
+	}
+	s.Children = make([]snapres, 0, len(children))
+	for _, c := range children {
+		s.Children = append(s.Children, newSnapres(c))
+	}
+	return s
+}
+
+func (s *snapres) setstat(r *Res) {
+	r.Size, r.Mode, r.ModTime, r.Link = s.Size, s.Mode, s.ModTime, s.Link
+}
+
+// load adds the children of r from the snapshot s. Directories that were not read
+// when the snapshot was taken are read.
+func load(r *Res, s *snapres, conf *Config) {
+	children := make([]*Res, 0, len(s.Children))
+	for i := range s.Children {
+		cs := &s.Children[i]
+		c := &Res{Name: cs.Name, Parent: r, Flag: r.Flag&FlagPoll | cs.Flag&(FlagDir|FlagLink)}
+		path := c.path(false)
+		c.Id = NewId(path)
+		cs.setstat(c)
+		if c.Flag&FlagDir != 0 {
+			c.Dir = &Dir{Path: path, fs: r.Dir.fs}
+		}
+		children = append(children, c)
+	}
+	r.Children = children
+	for i, c := range children {
+		if conf.filter(c) {
+			c.Flag |= FlagIgnore
+			continue
+		}
+		if c.Flag&FlagDir == 0 {
+			continue
+		}
+		if cs := &s.Children[i]; cs.Read {
+			load(c, cs, conf)
+		} else if err := read(c, conf); err != nil {
+			fmt.Println(err)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ws
+
+import (
+	"sync"
+)
+
+// Event is a resource event delivered to subscribers.
+type Event struct {
+	// Seq is the journal sequence number of the event.
+	Seq uint64
+	Op  Op
+	Id  Id
+	// Path is the resource path at the time of the event.
+	Path string
+	// Res is the resource, it may have changed since the event.
+	Res *Res
+}
+
+// Subscription delivers events of resources below a path prefix.
+// Events are buffered per subscription, slow subscribers never block the workspace.
+type Subscription struct {
+	sync.Mutex
+	ws     *Ws
+	prefix string
+	mask   Op
+	queue  []Event
+	notify chan bool
+	done   chan bool
+	c      chan Event
+	// C receives the events of channel subscriptions.
+	C <-chan Event
+}
+
+// Subscribe returns a subscription for events of resources at or below the path
+// prefix with any of the bits in mask. Events are sent on the subscription channel,
+// that is closed after the subscription is canceled. An empty prefix matches all paths.
+func (w *Ws) Subscribe(prefix string, mask Op) *Subscription {
+	s := w.subscribe(prefix, mask)
+	s.c = make(chan Event)
+	s.C = s.c
+	go s.run(func(e Event) {
+		select {
+		case s.c <- e:
+		case <-s.done:
+		}
+	})
+	return s
+}
+
+// SubscribeFunc returns a subscription like Subscribe that calls f for each event
+// in a separate goroutine.
+func (w *Ws) SubscribeFunc(prefix string, mask Op, f func(Event)) *Subscription {
+	s := w.subscribe(prefix, mask)
+	go s.run(f)
+	return s
+}
+
+func (w *Ws) subscribe(prefix string, mask Op) *Subscription {
+	s := &Subscription{
+		ws:     w,
+		prefix: prefix,
+		mask:   mask,
+		notify: make(chan bool, 1),
+		done:   make(chan bool),
+	}
+	w.Lock()
+	w.subs = append(w.subs, s)
+	w.Unlock()
+	return s
+}
+
+// Unsubscribe cancels the subscription and drops pending events.
+func (s *Subscription) Unsubscribe() {
+	w := s.ws
+	w.Lock()
+	for i, o := range w.subs {
+		if o == s {
+			w.subs = append(w.subs[:i], w.subs[i+1:]...)
+			break
+		}
+	}
+	w.Unlock()
+	s.cancel()
+}
+
+func (s *Subscription) cancel() {
+	s.Lock()
+	defer s.Unlock()
+	if s.notify == nil {
+		return
+	}
+	close(s.done)
+	s.queue, s.notify = nil, nil
+}
+
+// push queues the event if it matches the subscription.
+func (s *Subscription) push(e Event) {
+	if e.Op&s.mask == 0 || s.prefix != "" && !within(e.Path, s.prefix) {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	if s.notify == nil {
+		return
+	}
+	s.queue = append(s.queue, e)
+	select {
+	case s.notify <- true:
+	default:
+	}
+}
+
+func (s *Subscription) run(f func(Event)) {
+	s.Lock()
+	notify := s.notify
+	s.Unlock()
+	if s.c != nil {
+		defer close(s.c)
+	}
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-notify:
+		}
+		s.Lock()
+		queue := s.queue
+		s.queue = nil
+		s.Unlock()
+		for _, e := range queue {
+			select {
+			case <-s.done:
+				return
+			default:
+			}
+			f(e)
+		}
+	}
+}
+
+// handle calls the configured handler, journals the event and queues it for subscribers.
+// The caller must hold the write lock.
+func (w *Ws) handle(op Op, r *Res) {
+	w.config.handle(op, r)
+	path := r.path(false)
+	seq := w.journal.add(op, r.Id, path)
+	if len(w.subs) == 0 {
+		return
+	}
+	e := Event{seq, op, r.Id, path, r}
+	for _, s := range w.subs {
+		s.push(e)
+	}
+}
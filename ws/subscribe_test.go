@@ -0,0 +1,63 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ws
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSubscribe(t *testing.T) {
+	m := NewMemFS()
+	dir := "/s"
+	m.MkdirAll(dir + "/sub")
+	w := New(Config{CapHint: 100})
+	defer w.Close()
+	if _, err := w.MountFS(dir, m); err != nil {
+		t.Fatal(err)
+	}
+	sub := w.Subscribe(dir+"/sub", Add)
+	removed := make(chan string, 1)
+	all := w.SubscribeFunc("", Remove, func(e Event) {
+		removed <- e.Path
+	})
+	defer all.Unsubscribe()
+	// the unread channel subscription must not block the workspace
+	for i := 0; i < 10; i++ {
+		name := fmt.Sprintf("f%d", i)
+		if err := m.WriteFile(dir+"/sub/"+name, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.WriteFile(dir+"/other", nil); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		select {
+		case e := <-sub.C:
+			if path := fmt.Sprintf("%s/sub/f%d", dir, i); e.Op != Add|Create || e.Path != path {
+				t.Errorf("expected event %x %q got %x %q", Add|Create, path, e.Op, e.Path)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timeout")
+		}
+	}
+	sub.Unsubscribe()
+	if _, ok := <-sub.C; ok {
+		t.Error("channel not closed after unsubscribe")
+	}
+	if err := m.Remove(dir + "/other"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case path := <-removed:
+		if path != dir+"/other" {
+			t.Errorf("unexpected removed path %q", path)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ws
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// treeFS implements a read-only filesystem of a static file tree mounted at root.
+// The tree is never changed after it was built.
+type treeFS struct {
+	root  string
+	files map[string]*treefile
+	// open opens the content of files with a ref.
+	open func(f *treefile) (io.ReadCloser, error)
+}
+
+// treefile implements os.FileInfo.
+type treefile struct {
+	name string
+	mode os.FileMode
+	size int64
+	mod  time.Time
+	link string
+	data []byte
+	// ref identifies the content of files that are read on demand.
+	ref      string
+	children []*treefile
+}
+
+func (f *treefile) Name() string       { return f.name }
+func (f *treefile) Size() int64        { return f.size }
+func (f *treefile) Mode() os.FileMode  { return f.mode }
+func (f *treefile) ModTime() time.Time { return f.mod }
+func (f *treefile) IsDir() bool        { return f.mode.IsDir() }
+func (f *treefile) Sys() interface{}   { return nil }
+
+func newTreeFS(root string, mod time.Time) *treeFS {
+	root = filepath.Clean(root)
+	t := &treeFS{root: root, files: make(map[string]*treefile)}
+	t.files[root] = &treefile{name: filepath.Base(root), mode: os.ModeDir | 0555, mod: mod}
+	return t
+}
+
+// add adds f with the slash separated name relative to the root.
+// Names outside the root are skipped, files replace previous files with the same name.
+func (t *treeFS) add(name string, f *treefile) {
+	path := filepath.Join(t.root, filepath.FromSlash(name))
+	if path == t.root || !within(path, t.root) {
+		return
+	}
+	f.name = filepath.Base(path)
+	if f.data != nil {
+		f.size = int64(len(f.data))
+	}
+	old := t.files[path]
+	if old != nil && old.IsDir() && f.IsDir() {
+		old.mode, old.mod = f.mode, f.mod
+		return
+	}
+	if old != nil && old.IsDir() {
+		// directories are replaced by files with the same name
+		for p := range t.files {
+			if within(p, path) {
+				delete(t.files, p)
+			}
+		}
+	}
+	t.files[path] = f
+	replaceFile(t.dir(filepath.Dir(path), f.mod), old, f)
+}
+
+// dir returns the directory at path and adds missing directories.
+func (t *treeFS) dir(path string, mod time.Time) *treefile {
+	old := t.files[path]
+	if old != nil && old.IsDir() {
+		return old
+	}
+	// files are replaced by directories with the same name
+	d := &treefile{name: filepath.Base(path), mode: os.ModeDir | 0555, mod: mod}
+	t.files[path] = d
+	replaceFile(t.dir(filepath.Dir(path), mod), old, d)
+	return d
+}
+
+// replaceFile replaces old with f in the children of pa or adds f.
+func replaceFile(pa, old, f *treefile) {
+	for i, c := range pa.children {
+		if c == old {
+			pa.children[i] = f
+			return
+		}
+	}
+	pa.children = append(pa.children, f)
+}
+
+func (t *treeFS) ReadOnly() bool {
+	return true
+}
+
+func (t *treeFS) Lstat(path string) (os.FileInfo, error) {
+	f := t.files[filepath.Clean(path)]
+	if f == nil {
+		return nil, memerr("lstat", path, os.ErrNotExist)
+	}
+	return f, nil
+}
+
+// Stat returns the file info at path following one level of links.
+func (t *treeFS) Stat(path string) (os.FileInfo, error) {
+	f := t.files[filepath.Clean(path)]
+	if f == nil {
+		return nil, memerr("stat", path, os.ErrNotExist)
+	}
+	if f.link == "" {
+		return f, nil
+	}
+	target := f.link
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(path), target)
+	}
+	l := t.files[target]
+	if l == nil {
+		return nil, memerr("stat", path, os.ErrNotExist)
+	}
+	c := *l
+	c.name = f.name
+	return &c, nil
+}
+
+func (t *treeFS) ReadDir(path string) ([]os.FileInfo, error) {
+	d := t.files[filepath.Clean(path)]
+	if d == nil || !d.IsDir() {
+		return nil, memerr("readdir", path, os.ErrNotExist)
+	}
+	list := make([]os.FileInfo, 0, len(d.children))
+	for _, c := range d.children {
+		list = append(list, c)
+	}
+	sort.Sort(byInfoName(list))
+	return list, nil
+}
+
+func (t *treeFS) Readlink(path string) (string, error) {
+	f := t.files[filepath.Clean(path)]
+	if f == nil || f.link == "" {
+		return "", memerr("readlink", path, os.ErrInvalid)
+	}
+	return f.link, nil
+}
+
+func (t *treeFS) Open(path string) (io.ReadCloser, error) {
+	f := t.files[filepath.Clean(path)]
+	switch {
+	case f == nil:
+		return nil, memerr("open", path, os.ErrNotExist)
+	case f.IsDir():
+		return nil, memerr("open", path, os.ErrInvalid)
+	case f.ref != "" && t.open != nil:
+		return t.open(f)
+	}
+	return ioutil.NopCloser(bytes.NewReader(f.data)), nil
+}
+
+// Watcher returns nil, static trees never change.
+func (t *treeFS) Watcher(Controller) (Watcher, error) {
+	return nil, nil
+}
@@ -6,6 +6,7 @@ package ws
 
 import (
 	"runtime"
+	"sort"
 	"sync"
 	"time"
 )
@@ -70,6 +71,17 @@ func (q *Queue) Work() []*Res {
 	return res
 }
 
+func (q *Queue) has(id Id) bool {
+	q.Lock()
+	defer q.Unlock()
+	for _, qr := range q.queue {
+		if qr.Id == id {
+			return true
+		}
+	}
+	return false
+}
+
 func (q *Queue) del(r *Res) {
 	for i, qr := range q.queue {
 		if qr.Id == r.Id {
@@ -81,38 +93,67 @@ func (q *Queue) del(r *Res) {
 
 // Throttle manages a ticker and swaps two queue when worked.
 // New tickers are sent to the Tickers channel and run as long as work is available.
+// Resources added again before they are worked wait for another tick, unless they
+// waited longer than MaxWait or have a positive priority class.
 type Throttle struct {
 	sync.Mutex
 	queue, batch *Queue
+	since        map[Id]time.Time
+	class        map[Id]int
+	priority     func(*Res) int
 
 	delay   time.Duration
 	ticker  *time.Ticker
 	Tickers chan *time.Ticker
+	// MaxWait bounds the time between the first add and work of a resource if positive.
+	MaxWait time.Duration
 }
 
 func NewThrottle(delay time.Duration) *Throttle {
 	return &Throttle{
 		queue:   &Queue{},
 		batch:   &Queue{},
+		since:   make(map[Id]time.Time),
+		class:   make(map[Id]int),
 		delay:   delay,
 		Tickers: make(chan *time.Ticker, 1),
 	}
 }
 
+// SetPriority sets the function returning the priority class of added resources.
+// Worked resources are returned ordered by descending class.
+func (q *Throttle) SetPriority(f func(*Res) int) {
+	q.Lock()
+	defer q.Unlock()
+	q.priority = f
+}
+
 // Delete dequeues the resource.
 func (q *Throttle) Delete(r *Res) {
 	q.Lock()
 	defer q.Unlock()
 	q.queue.Delete(r)
 	q.batch.Delete(r)
+	delete(q.since, r.Id)
+	delete(q.class, r.Id)
 }
 
 // Add enqueues the resource and starts a ticker if necessary.
 func (q *Throttle) Add(r *Res) {
 	q.Lock()
 	defer q.Unlock()
-	q.batch.Delete(r)
-	q.queue.Add(r)
+	now := time.Now()
+	if _, ok := q.since[r.Id]; !ok {
+		q.since[r.Id] = now
+	}
+	if q.priority != nil {
+		q.class[r.Id] = q.priority(r)
+	}
+	// urgent resources stay in the batch
+	if !q.urgent(r.Id, now) || !q.batch.has(r.Id) {
+		q.batch.Delete(r)
+		q.queue.Add(r)
+	}
 	if q.ticker == nil {
 		q.ticker = time.NewTicker(q.delay)
 		q.Tickers <- q.ticker
@@ -124,10 +165,50 @@ func (q *Throttle) Work() []*Res {
 	q.Lock()
 	defer q.Unlock()
 	res := q.batch.Work()
+	now := time.Now()
+	// overdue resources skip the batch
+	if q.MaxWait > 0 {
+		q.queue.Lock()
+		queue := q.queue.queue[:0]
+		for _, r := range q.queue.queue {
+			if now.Sub(q.since[r.Id]) >= q.MaxWait {
+				res = append(res, r)
+			} else {
+				queue = append(queue, r)
+			}
+		}
+		q.queue.queue = queue
+		q.queue.Unlock()
+	}
 	q.batch, q.queue = q.queue, q.batch
 	if len(q.batch.queue) == 0 && q.ticker != nil {
 		q.ticker.Stop()
 		q.ticker = nil
 	}
+	sort.Stable(byClass{res, q.class})
+	for _, r := range res {
+		delete(q.since, r.Id)
+		delete(q.class, r.Id)
+	}
 	return res
 }
+
+// urgent returns whether the resource with id must be worked with the next batch.
+func (q *Throttle) urgent(id Id, now time.Time) bool {
+	return q.class[id] > 0 || q.MaxWait > 0 && now.Sub(q.since[id]) >= q.MaxWait
+}
+
+type byClass struct {
+	list  []*Res
+	class map[Id]int
+}
+
+func (l byClass) Len() int {
+	return len(l.list)
+}
+func (l byClass) Less(i, j int) bool {
+	return l.class[l.list[i].Id] > l.class[l.list[j].Id]
+}
+func (l byClass) Swap(i, j int) {
+	l.list[i], l.list[j] = l.list[j], l.list[i]
+}
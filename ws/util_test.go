@@ -0,0 +1,87 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ws
+
+import (
+	"testing"
+	"time"
+)
+
+func names(list []*Res) string {
+	var s string
+	for _, r := range list {
+		s += r.Name
+	}
+	return s
+}
+
+// newTestThrottle returns a throttle with a buffered tickers channel.
+func newTestThrottle() *Throttle {
+	q := NewThrottle(time.Hour)
+	q.Tickers = make(chan *time.Ticker, 100)
+	return q
+}
+
+func TestThrottleStarvation(t *testing.T) {
+	a := &Res{Id: 1, Name: "a"}
+	q := newTestThrottle()
+	// without a bound, resources added on every tick are never worked
+	for i := 0; i < 5; i++ {
+		q.Add(a)
+		if got := names(q.Work()); got != "" {
+			t.Fatalf("unbounded throttle worked %q", got)
+		}
+	}
+	q = newTestThrottle()
+	q.MaxWait = 20 * time.Millisecond
+	start := time.Now()
+	var got string
+	for got == "" && time.Since(start) < time.Second {
+		q.Add(a)
+		got = names(q.Work())
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got != "a" {
+		t.Fatalf("starved resource not worked")
+	}
+	if wait := time.Since(start); wait > 100*time.Millisecond {
+		t.Errorf("resource worked after %s", wait)
+	}
+	if got := names(q.Work()); got != "" {
+		t.Errorf("worked resource not dequeued %q", got)
+	}
+}
+
+func TestThrottlePriority(t *testing.T) {
+	a, b, c := &Res{Id: 1, Name: "a"}, &Res{Id: 2, Name: "b"}, &Res{Id: 3, Name: "c"}
+	q := newTestThrottle()
+	q.SetPriority(func(r *Res) int {
+		if r == b {
+			return 1
+		}
+		return 0
+	})
+	q.Add(a)
+	q.Add(b)
+	q.Add(c)
+	if got := names(q.Work()); got != "" {
+		t.Errorf("expected empty batch got %q", got)
+	}
+	if got := names(q.Work()); got != "bac" {
+		t.Errorf("expected priority order %q got %q", "bac", got)
+	}
+	q.Add(a)
+	q.Add(b)
+	q.Work()
+	// a steady stream of changes must not starve the prioritized resource
+	q.Add(a)
+	q.Add(b)
+	if got := names(q.Work()); got != "b" {
+		t.Errorf("expected prioritized resource got %q", got)
+	}
+	if got := names(q.Work()); got != "a" {
+		t.Errorf("expected remaining resource got %q", got)
+	}
+}
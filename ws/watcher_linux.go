@@ -11,6 +11,7 @@ import (
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 	"unsafe"
 )
 
@@ -21,11 +22,17 @@ var (
 	flagMask          = (createMask | modifyMask | deleteMask) ^ syscall.IN_DELETE_SELF
 )
 
+// moveWait is how long a moved-from event at the end of the read events waits
+// for its moved-to event before it is reported as delete.
+var moveWait = 10 * time.Millisecond
+
 type inotify struct {
 	sync.Mutex
 	watchfd int
+	// pollfd is an epoll instance waiting for watchfd to be readable.
+	pollfd  int
 	watches map[Id]int32
-	ids     map[int32]Id
+	ids     map[int32][]Id
 	done    chan bool
 	ctrler  Controller
 }
@@ -35,10 +42,22 @@ func NewInotify(ctrler Controller) (Watcher, error) {
 	if watchfd == -1 {
 		return nil, os.NewSyscallError("inotify_init", errno)
 	}
+	pollfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		syscall.Close(watchfd)
+		return nil, os.NewSyscallError("epoll_create1", err)
+	}
+	ev := syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(watchfd)}
+	if err = syscall.EpollCtl(pollfd, syscall.EPOLL_CTL_ADD, watchfd, &ev); err != nil {
+		syscall.Close(pollfd)
+		syscall.Close(watchfd)
+		return nil, os.NewSyscallError("epoll_ctl", err)
+	}
 	w := &inotify{
 		watchfd: watchfd,
+		pollfd:  pollfd,
 		watches: make(map[Id]int32),
-		ids:     make(map[int32]Id),
+		ids:     make(map[int32][]Id),
 		done:    make(chan bool, 1),
 		ctrler:  ctrler,
 	}
@@ -59,15 +78,21 @@ func (w *inotify) Watch(r *Res) error {
 	return w.add(r.Id, r.Path(), flags)
 }
 func (w *inotify) add(id Id, path string, flags uint32) error {
-	wd, err := syscall.InotifyAddWatch(w.watchfd, path, flags)
+	// linked directories share one watch
+	wd, err := syscall.InotifyAddWatch(w.watchfd, path, flags|syscall.IN_MASK_ADD)
 	if err != nil {
 		return err
 	}
 	watch := int32(wd)
 	w.watches[id] = watch
-	w.ids[watch] = id
+	w.ids[watch] = append(w.ids[watch], id)
 	return nil
 }
+func (w *inotify) Unwatch(id Id) error {
+	w.Lock()
+	defer w.Unlock()
+	return w.remove(id)
+}
 func (w *inotify) Close() error {
 	w.Lock()
 	defer w.Unlock()
@@ -91,23 +116,44 @@ func (w *inotify) remove(id Id) error {
 	if !ok {
 		return fmt.Errorf("can't remove non-existent inotify watch for: %x", id)
 	}
+	delete(w.watches, id)
+	// keep the watch ids until the watch is ignored
+	ids := without(w.ids[watch], id)
+	w.ids[watch] = ids
+	if len(ids) > 0 {
+		return nil
+	}
 	success, errno := syscall.InotifyRmWatch(w.watchfd, uint32(watch))
 	if success == -1 {
 		return os.NewSyscallError("inotify_rm_watch", errno)
 	}
-	delete(w.watches, id)
 	return nil
 }
+
+// moved holds an unpaired moved-from event.
+type moved struct {
+	cookie uint32
+	wd     int32
+	ids    []Id
+	name   string
+}
+
 func (w *inotify) readEvents() {
 	var buf [syscall.SizeofInotifyEvent * 4096]byte
+	var from *moved
+	// last is the time events were last read completely
+	last := time.Now()
 	for {
 		n, err := syscall.Read(w.watchfd, buf[:])
+		since, overflow := last, false
+		last = time.Now()
 		var done bool
 		select {
 		case done = <-w.done:
 		default:
 		}
 		if n == 0 || done {
+			syscall.Close(w.pollfd)
 			err = syscall.Close(w.watchfd)
 			if err != nil {
 				log.Println(os.NewSyscallError("close", err))
@@ -134,19 +180,27 @@ func (w *inotify) readEvents() {
 		for offset <= uint32(n-syscall.SizeofInotifyEvent) {
 			// Point "raw" to the event in the buffer
 			raw := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			if raw.Mask&syscall.IN_Q_OVERFLOW != 0 {
+				// events were dropped, rescan after the buffer is read
+				overflow = true
+				offset += syscall.SizeofInotifyEvent + raw.Len
+				continue
+			}
 			// If the event happened to the watched directory or the watched file, the kernel
 			// doesn't append the filename to the event, but we would like to always fill the
 			// the "Name" field with a valid filename. We retrieve the path of the watch from
 			// the "paths" map.
 			w.Lock()
-			id, ok := w.ids[raw.Wd]
+			ids, ok := w.ids[raw.Wd]
 			if !ok {
-				log.Println("inotify: no resource found with id", id)
+				log.Println("inotify: no resource found with watch", raw.Wd)
 			}
 			// Check if the the watch was removed
 			if raw.Mask&syscall.IN_IGNORED != 0 {
 				// remove stale watch
-				delete(w.watches, id)
+				for _, id := range ids {
+					delete(w.watches, id)
+				}
 				delete(w.ids, raw.Wd)
 			}
 			w.Unlock()
@@ -157,8 +211,18 @@ func (w *inotify) readEvents() {
 				// The filename is padded with NUL bytes. TrimRight() gets rid of those.
 				name = strings.TrimRight(string(bytes[0:raw.Len]), "\000")
 			}
+			// pair moved-from and moved-to events by cookie
+			if from != nil && (raw.Mask&syscall.IN_MOVED_TO == 0 || raw.Cookie != from.cookie) {
+				w.control(Delete, from.ids, from.name)
+				from = nil
+			}
 			var op Op
 			switch {
+			case raw.Mask&syscall.IN_MOVED_FROM != 0 && name != "":
+				from = &moved{raw.Cookie, raw.Wd, ids, name}
+			case raw.Mask&syscall.IN_MOVED_TO != 0 && from != nil && name != "":
+				w.move(from, raw.Wd, ids, name)
+				from = nil
 			case raw.Mask&createMask != 0 && name != "":
 				op = Create
 			case raw.Mask&modifyMask != 0 && name != "":
@@ -167,12 +231,55 @@ func (w *inotify) readEvents() {
 				op = Delete
 			}
 			if op != 0 {
-				if err = w.ctrler.Control(op, id, name); err != nil {
-					log.Println(err)
-				}
+				w.control(op, ids, name)
 			} // else log unexpected?
 			// Move to the next event in the buffer
 			offset += syscall.SizeofInotifyEvent + raw.Len
 		}
+		// keep unpaired moves only if more events follow shortly
+		if from != nil && !w.readable(moveWait) {
+			w.control(Delete, from.ids, from.name)
+			from = nil
+		}
+		if overflow {
+			log.Println("inotify: queue overflow, rescanning")
+			// allow for coarse modification time granularity
+			if err = w.ctrler.Rescan(since.Add(-time.Second)); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+}
+
+// readable returns whether events can be read within timeout.
+func (w *inotify) readable(timeout time.Duration) bool {
+	var events [1]syscall.EpollEvent
+	n, err := syscall.EpollWait(w.pollfd, events[:], int(timeout/time.Millisecond))
+	return err == nil && n > 0
+}
+func (w *inotify) control(op Op, ids []Id, name string) {
+	for _, id := range ids {
+		if err := w.ctrler.Control(op, id, name); err != nil {
+			log.Println(err)
+		}
+	}
+}
+func (w *inotify) move(from *moved, wd int32, ids []Id, name string) {
+	switch {
+	case from.wd == wd:
+		// moved within a possibly linked directory
+		for _, id := range ids {
+			if err := w.ctrler.Move(id, from.name, id, name); err != nil {
+				log.Println(err)
+			}
+		}
+	case len(from.ids) == 1 && len(ids) == 1:
+		if err := w.ctrler.Move(from.ids[0], from.name, ids[0], name); err != nil {
+			log.Println(err)
+		}
+	default:
+		// moves between linked directories can not be paired
+		w.control(Delete, from.ids, from.name)
+		w.control(Create, ids, name)
 	}
 }
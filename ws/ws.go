@@ -7,20 +7,26 @@ package ws
 
 import (
 	"fmt"
+	"log"
 	"os"
 	"path/filepath"
 	"runtime"
 	"sort"
 	"sync"
+	"syscall"
+	"time"
 )
 
 // Watcher provides and interface for workspace watchers.
 type Watcher interface {
 	Watch(r *Res) error
+	// Unwatch stops watching the directory with id.
+	Unwatch(id Id) error
 	Close() error
 }
 
 // Op describes workspace and filesystem operations or events
+// Moved resources are handled with Move|Remove before and Move|Add after they moved.
 type Op uint
 
 const (
@@ -31,7 +37,7 @@ const (
 	Create
 	Modify
 	Delete
-	_
+	Move
 	WsMask Op = 0x0F
 	FsMask Op = 0xF0
 )
@@ -42,6 +48,18 @@ type Filter interface {
 	Filter(*Res) bool
 }
 
+// LinkPolicy describes how symbolic links in the workspace are handled.
+type LinkPolicy uint
+
+const (
+	// LinkMark adds links flagged with FlagLink without following them.
+	LinkMark LinkPolicy = iota
+	// LinkFollow follows links to directories unless they link to an ancestor.
+	LinkFollow
+	// LinkIgnore flags links with FlagIgnore.
+	LinkIgnore
+)
+
 // Handler handles resource operation events.
 type Handler interface {
 	Handle(Op, *Res)
@@ -58,9 +76,27 @@ type Config struct {
 	Handler Handler
 	// Filter filters resources if set.
 	Filter Filter
+	// Poll returns whether the mount at path should be polled instead of watched.
+	// Directories are also polled if the watcher fails with ENOSPC.
+	Poll func(path string) bool
+	// PollInterval is the interval used for polling, defaults to DefaultPollInterval.
+	PollInterval time.Duration
+	// Links is the policy for symbolic links.
+	Links LinkPolicy
+	// Coalesce is the window in which watcher events are folded per resource
+	// into their net operation, for example delete and create into modify.
+	// Events are passed on immediately if zero.
+	Coalesce time.Duration
+	// Snapshot is used to mount cached trees if set.
+	Snapshot *Snapshot
+	// JournalSize is the number of events kept in the journal, defaults to DefaultJournalSize.
+	JournalSize int
 }
 
 func (c *Config) filter(r *Res) bool {
+	if r.Flag&FlagLink != 0 && c.Links == LinkIgnore {
+		return true
+	}
 	if c.Filter != nil {
 		return c.Filter.Filter(r)
 	}
@@ -76,6 +112,11 @@ func (c *Config) handle(op Op, r *Res) {
 // Controller provides an interface for the watcher to modify the workspace.
 type Controller interface {
 	Control(op Op, id Id, name string) error
+	// Move moves the child fromname of from to the child toname of to.
+	Move(from Id, fromname string, to Id, toname string) error
+	// Rescan reads the watched mounts and controls missed events.
+	// Files with changed metadata or modified since are reported as modified.
+	Rescan(since time.Time) error
 }
 
 // Ws implements a workspace that manages all mounted resources.
@@ -84,7 +125,19 @@ type Ws struct {
 	config  Config
 	root    *Res
 	all     map[Id]*Res
+	names   *nameIndex
+	echo    map[Id]echo
+	subs    []*Subscription
+	journal journal
+	ctrler  Controller
 	watcher Watcher
+	poller  Watcher
+	// fswatchers holds the watchers of filesystems other than disk.
+	fswatchers map[FS]Watcher
+	// collisions counts the resources with colliding ids.
+	collisions int
+	// coll holds the resources with colliding ids by path.
+	coll map[string]*Res
 }
 
 // New creates a workspace with configuration c.
@@ -94,40 +147,104 @@ func New(c Config) *Ws {
 		name = "/"
 	}
 	r := &Res{Id: NewId(name), Name: name}
-	w := &Ws{config: c, root: r, all: make(map[Id]*Res, c.CapHint)}
-	w.all[r.Id] = r
+	w := &Ws{config: c, root: r, all: make(map[Id]*Res, c.CapHint), names: newNameIndex(c.CapHint)}
+	w.journal = newJournal(c.JournalSize)
+	w.put(r)
+	w.ctrler = (*ctrl)(w)
+	if c.Coalesce > 0 {
+		w.ctrler = newCoalescer((*ctrl)(w), c.Coalesce)
+	}
 	return w
 }
 
-// Mount adds the directory tree at path to the workspace.
+// Mount adds the directory tree at path on disk to the workspace.
 func (w *Ws) Mount(path string) (*Res, error) {
-	fi, err := os.Stat(path)
+	return w.MountFS(path, Disk)
+}
+
+// MountFS adds the directory tree at path of the filesystem fs to the workspace.
+func (w *Ws) MountFS(path string, fs FS) (*Res, error) {
+	fi, err := fs.Stat(path)
 	if err != nil {
 		return nil, err
 	}
 	if !fi.IsDir() {
 		return nil, fmt.Errorf("not a directory")
 	}
-	r, err := w.mount(path)
+	r, err := w.mount(path, fs)
 	if err != nil {
 		return r, err
 	}
+	var snap *snapres
+	if fs == Disk {
+		snap = w.config.Snapshot.find(r.Dir.Path)
+	}
+	r.Lock()
+	if snap != nil {
+		// keep the cached modification time for validation
+		snap.setstat(r)
+	} else {
+		setstat(r, fs, r.Dir.Path, fi)
+	}
+	r.Unlock()
 	if w.config.filter(r) {
+		w.Lock()
 		r.Flag |= FlagIgnore
+		w.index(r)
+		w.Unlock()
 		return r, nil
 	}
 	r.Lock()
-	err = read(r, w.config.Filter)
+	if snap != nil && snap.Read {
+		load(r, snap, &w.config)
+	} else {
+		snap = nil
+		err = read(r, &w.config)
+	}
 	r.Unlock()
 	if err != nil {
 		return r, err
 	}
 	w.Lock()
-	defer w.Unlock()
 	w.addAllChildren(0, r)
+	w.Unlock()
+	if snap != nil {
+		go (*ctrl)(w).validate(r)
+	}
 	return r, nil
 }
 
+// Unmount removes the directory tree mounted at path from the workspace.
+// Logical parents without children are removed as well.
+func (w *Ws) Unmount(path string) error {
+	path = filepath.Clean(path)
+	w.Lock()
+	defer w.Unlock()
+	r := w.lookup(path)
+	if r == nil || r.Flag&FlagMount == 0 {
+		return fmt.Errorf("not mounted")
+	}
+	p := r.Parent
+	if err := (*ctrl)(w).remove(0, r); err != nil {
+		return err
+	}
+	for p != w.root && p.Flag&FlagLogical != 0 {
+		p.Lock()
+		empty := len(p.Children) == 0
+		p.Unlock()
+		if !empty {
+			break
+		}
+		pa := p.Parent
+		pa.Lock()
+		pa.Children = remove(pa.Children, p)
+		pa.Unlock()
+		w.drop(p)
+		p = pa
+	}
+	return nil
+}
+
 // Res returns the resource with id or nil.
 func (w *Ws) Res(id Id) *Res {
 	w.RLock()
@@ -135,6 +252,20 @@ func (w *Ws) Res(id Id) *Res {
 	return w.all[id]
 }
 
+// resPath returns the resource at path or nil.
+func (w *Ws) resPath(path string) *Res {
+	w.RLock()
+	defer w.RUnlock()
+	return w.lookup(path)
+}
+
+// Collisions returns the number of resources added with colliding ids.
+func (w *Ws) Collisions() int {
+	w.RLock()
+	defer w.RUnlock()
+	return w.collisions
+}
+
 // Walk calls visit for all resources in list and all their descendants.
 // If the visit returns Skip for a resource its children are not visited.
 func (w *Ws) Walk(list []*Res, visit func(r *Res) error) error {
@@ -142,29 +273,34 @@ func (w *Ws) Walk(list []*Res, visit func(r *Res) error) error {
 	defer w.RUnlock()
 	return walk(list, visit)
 }
-func (w *Ws) mount(path string) (*Res, error) {
+func (w *Ws) mount(path string, fs FS) (*Res, error) {
 	path = filepath.Clean(path)
 	id := NewId(path)
 	d, f := filepath.Split(path)
 	w.Lock()
 	defer w.Unlock()
-	if w.watcher == nil && w.config.Watcher != nil {
-		watcher, err := w.config.Watcher((*ctrl)(w))
+	if fs == Disk && w.watcher == nil && w.config.Watcher != nil {
+		watcher, err := w.config.Watcher(w.ctrler)
 		if err != nil {
 			return nil, err
 		}
 		w.watcher = watcher
 	}
-	r, ok := w.all[id]
-	if ok {
+	if r := w.lookup(path); r != nil {
 		return r, fmt.Errorf("duplicate")
 	}
-	r = &Res{Id: id, Name: f, Flag: FlagDir | FlagMount, Dir: &Dir{Path: path}}
+	r := &Res{Id: id, Name: f, Flag: FlagDir | FlagMount, Dir: &Dir{Path: path, fs: fs}}
+	if fs == Disk && w.config.Poll != nil && w.config.Poll(path) {
+		r.Flag |= FlagPoll
+	}
+	if readOnly(fs) {
+		r.Flag |= FlagReadOnly
+	}
 	// add virtual parent
 	r.Parent = w.logicalParent(d)
 	r.Parent.Children = insert(r.Parent.Children, r)
-	w.all[id] = r
-	w.config.handle(Add, r)
+	w.put(r)
+	w.handle(Add, r)
 	return r, nil
 }
 
@@ -177,6 +313,23 @@ func (w *Ws) Close() {
 		w.watcher.Close()
 		w.watcher = nil
 	}
+	if w.poller != nil {
+		w.poller.Close()
+		w.poller = nil
+	}
+	for _, watcher := range w.fswatchers {
+		if watcher != nil {
+			watcher.Close()
+		}
+	}
+	w.fswatchers = nil
+	if c, ok := w.ctrler.(*coalescer); ok {
+		c.stop()
+	}
+	for _, s := range w.subs {
+		s.cancel()
+	}
+	w.subs = nil
 	// scatter garbage
 	for id, r := range w.all {
 		r.Lock()
@@ -185,8 +338,61 @@ func (w *Ws) Close() {
 		delete(w.all, id)
 	}
 	w.all = nil
+	w.names = nil
 	w.root = nil
 }
+
+// lookup returns the resource at path or nil. The caller must hold the lock.
+func (w *Ws) lookup(path string) *Res {
+	r := w.all[NewId(path)]
+	if w.collisions > 0 && (r == nil || r.path(false) != path) {
+		return w.coll[path]
+	}
+	return r
+}
+
+// put adds r to the id map. Id collisions are reported and the colliding resource
+// is kept by path, it is reachable from its parent and by path lookups.
+func (w *Ws) put(r *Res) {
+	if o, ok := w.all[r.Id]; ok && o != r {
+		if op, rp := o.path(false), r.path(false); op != rp {
+			if w.coll == nil {
+				w.coll = make(map[string]*Res)
+			}
+			if w.coll[rp] == nil {
+				w.collisions++
+				log.Printf("ws: id collision %X for %s and %s\n", r.Id, op, rp)
+			}
+			w.coll[rp] = r
+			return
+		}
+	}
+	w.all[r.Id] = r
+	w.index(r)
+}
+
+// drop removes r from the id map. A resource with the same id takes its place.
+func (w *Ws) drop(r *Res) {
+	if len(w.coll) > 0 {
+		if path := r.path(false); w.coll[path] == r {
+			delete(w.coll, path)
+			return
+		}
+	}
+	if w.all[r.Id] != r {
+		return
+	}
+	delete(w.all, r.Id)
+	w.names.del(r.Id)
+	for path, c := range w.coll {
+		if c.Id == r.Id {
+			delete(w.coll, path)
+			w.all[c.Id] = c
+			w.index(c)
+			break
+		}
+	}
+}
 func (w *Ws) logicalParent(path string) *Res {
 	parts := split(path)
 	r := w.root
@@ -202,7 +408,11 @@ func (w *Ws) logicalParent(path string) *Res {
 		c.Dir = &Dir{Path: p}
 		c.Id = NewId(p)
 		r.Children = insert(r.Children, c)
-		w.all[c.Id], r = c, c
+		w.put(c)
+		r = c
+	}
+	if r.Dir == nil {
+		r.Dir = &Dir{Path: r.Path()}
 	}
 	return r
 }
@@ -225,30 +435,25 @@ func split(path string) []string {
 	}
 	return parts
 }
-func read(r *Res, filter Filter) error {
-	f, err := os.Open(r.Dir.Path)
-	if err != nil {
-		return err
-	}
-	list, err := f.Readdir(-1)
-	f.Close()
+func read(r *Res, conf *Config) error {
+	list, err := r.fs(false).ReadDir(r.Dir.Path)
 	if err != nil {
 		return err
 	}
 	children := make([]*Res, 0, len(list))
 	for _, fi := range list {
-		c, _ := newChild(r, fi.Name(), fi.IsDir(), false)
+		c, _ := newChild(r, fi.Name(), fi, conf.Links)
 		children = append(children, c)
 	}
 	sort.Sort(byTypeAndName(children))
 	r.Children = children
 	for _, c := range children {
-		if filter != nil && filter.Filter(c) {
+		if conf.filter(c) {
 			c.Flag |= FlagIgnore
 			continue
 		}
 		if c.Flag&FlagDir != 0 {
-			if err := read(c, filter); err != nil {
+			if err := read(c, conf); err != nil {
 				fmt.Println(err)
 			}
 		}
@@ -257,17 +462,59 @@ func read(r *Res, filter Filter) error {
 }
 func (w *Ws) addAllChildren(fsop Op, r *Res) {
 	for _, c := range r.Children {
-		w.all[c.Id] = c
-		w.config.handle(fsop|Add, c)
+		w.put(c)
+		w.handle(fsop|Add, c)
 		if c.Flag&(FlagDir|FlagIgnore) == FlagDir {
 			w.addAllChildren(fsop, c)
 		}
 	}
-	if w.watcher != nil {
+	w.watch(r)
+	w.handle(fsop|Change, r)
+}
+func (w *Ws) watch(r *Res) {
+	if fs := r.fs(true); fs != Disk {
+		w.watchfs(fs, r)
+		return
+	}
+	if r.Flag&FlagPoll == 0 {
+		if w.watcher == nil {
+			return
+		}
 		err := w.watcher.Watch(r)
-		if err != nil {
+		if err != syscall.ENOSPC {
+			if err != nil {
+				fmt.Println(err)
+			}
+			return
+		}
+		fmt.Println("watch limit reached, polling", r.Path())
+		r.Flag |= FlagPoll
+	}
+	if w.poller == nil {
+		w.poller = newPoller(w.ctrler, w.config.PollInterval)
+	}
+	if err := w.poller.Watch(r); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// watchfs watches the directory r with the watcher of the filesystem fs.
+func (w *Ws) watchfs(fs FS, r *Res) {
+	watcher, ok := w.fswatchers[fs]
+	if !ok {
+		var err error
+		if watcher, err = fs.Watcher(w.ctrler); err != nil {
 			fmt.Println(err)
 		}
+		if w.fswatchers == nil {
+			w.fswatchers = make(map[FS]Watcher)
+		}
+		w.fswatchers[fs] = watcher
+	}
+	if watcher == nil {
+		return
+	}
+	if err := watcher.Watch(r); err != nil {
+		fmt.Println(err)
 	}
-	w.config.handle(fsop|Change, r)
 }
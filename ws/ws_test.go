@@ -5,6 +5,7 @@
 package ws
 
 import (
+	"bytes"
 	"fmt"
 	"go/build"
 	"io/ioutil"
@@ -54,16 +55,15 @@ func TestWalkSrc(t *testing.T) {
 	t.Log(gcandstat())
 }
 
-type testhandler chan struct {
+type testevent struct {
 	Op
-	*Res
+	Path string
 }
 
+type testhandler chan testevent
+
 func (h testhandler) Handle(op Op, r *Res) {
-	h <- struct {
-		Op
-		*Res
-	}{op, r}
+	h <- testevent{op, r.path(false)}
 }
 
 func TestWatch(t *testing.T) {
@@ -84,7 +84,7 @@ func TestWatch(t *testing.T) {
 		for _, op := range ops {
 			select {
 			case e := <-events:
-				p := e.Path()
+				p := e.Path
 				if e.Op != op || p != path {
 					t.Errorf("expected event %x %q got %x %q\n", op, path, e.Op, p)
 				}
@@ -129,13 +129,48 @@ func TestWatch(t *testing.T) {
 
 	err = os.Rename(subsubdir, dir+"/sub")
 	fail("mv", err)
-	expect(otherfile, Remove|Delete)
-	expect(subsubdir, Remove|Delete)
+	expect(otherfile, Move|Remove)
+	expect(subsubdir, Move|Remove)
 	subsubdir = dir + "/sub"
 	otherfile = dir + "/sub/otherfile"
-	expect(subsubdir, Add|Create)
-	expect(otherfile, Add|Create)
-	expect(subsubdir, Change|Create)
+	expect(subsubdir, Move|Add)
+	expect(otherfile, Move|Add)
+	expect(subsubdir, Move|Change)
+	if res := w.Res(NewId(otherfile)); res == nil || res.Parent.Id != NewId(subsubdir) {
+		t.Error("moved file not found")
+	}
+
+	movedfile := dir + "/movedfile"
+	err = os.Rename(file, movedfile)
+	fail("mv file", err)
+	expect(file, Move|Remove)
+	expect(movedfile, Move|Add)
+
+	file = createfile("/testfile")
+	expect(file, Add|Create, Change|Modify)
+
+	err = ioutil.WriteFile(movedfile, []byte("moved"), 0666)
+	fail("write movedfile", err)
+	expect(movedfile, Change|Modify)
+
+	err = os.Rename(movedfile, file)
+	fail("mv replace", err)
+	expect(movedfile, Remove|Delete)
+	expect(file, Change|Modify)
+
+	// moves out of the workspace are reported as delete
+	outdir, err := ioutil.TempDir("", "wsinotifyout")
+	fail("create out dir", err)
+	defer os.RemoveAll(outdir)
+	outfile := createfile("/outfile")
+	expect(outfile, Add|Create, Change|Modify)
+	err = os.Rename(outfile, outdir+"/outfile")
+	fail("mv out", err)
+	expect(outfile, Remove|Delete)
+
+	// writes without changes are not reported
+	err = ioutil.WriteFile(file, []byte("moved"), 0666)
+	fail("rewrite testfile", err)
 
 	os.RemoveAll(dir)
 	expect(file, Remove|Delete)
@@ -144,12 +179,332 @@ func TestWatch(t *testing.T) {
 	expect(subsubdir, Remove|Delete)
 	expect(dir, Remove|Delete)
 
-	if _, ok := w.all[r.Id]; ok {
+	if w.Res(r.Id) != nil {
 		t.Error("dir still exists after remove")
 	}
 	w.Close()
 }
 
+func TestPoll(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wspoll")
+	if err != nil {
+		t.Fatal("failed to create temp dir")
+	}
+	defer os.RemoveAll(dir)
+	events := make(testhandler, 10)
+	w := New(Config{
+		CapHint:      100,
+		Handler:      events,
+		Poll:         func(string) bool { return true },
+		PollInterval: 10 * time.Millisecond,
+	})
+	defer w.Close()
+	fail := func(msg string, err error) {
+		if err != nil {
+			t.Fatalf("%s: %s", msg, err)
+		}
+	}
+	expect := func(path string, ops ...Op) {
+		for _, op := range ops {
+			select {
+			case e := <-events:
+				p := e.Path
+				if e.Op != op || p != path {
+					t.Errorf("expected event %x %q got %x %q\n", op, path, e.Op, p)
+				}
+			case <-time.After(1 * time.Second):
+				t.Fatalf("expected event %x %q\n got timeout", op, path)
+			}
+		}
+	}
+	r, err := w.Mount(dir)
+	fail("mount", err)
+	if r.Flag&FlagPoll == 0 {
+		t.Error("mount not flagged for polling")
+	}
+	expect(dir, Add, Change)
+
+	file := dir + "/testfile"
+	err = ioutil.WriteFile(file, nil, 0666)
+	fail("create testfile", err)
+	expect(file, Add|Create)
+
+	err = ioutil.WriteFile(file, []byte("changed"), 0666)
+	fail("modify testfile", err)
+	expect(file, Change|Modify)
+
+	subdir := dir + "/testdir"
+	err = os.Mkdir(subdir, 0777)
+	fail("subdir", err)
+	expect(subdir, Add|Create, Change|Create)
+
+	otherfile := subdir + "/otherfile"
+	err = ioutil.WriteFile(otherfile, nil, 0666)
+	fail("create otherfile", err)
+	expect(otherfile, Add|Create)
+
+	err = os.RemoveAll(subdir)
+	fail("remove subdir", err)
+	expect(otherfile, Remove|Delete)
+	expect(subdir, Remove|Delete)
+
+	err = os.RemoveAll(dir)
+	fail("remove dir", err)
+	expect(file, Remove|Delete)
+	expect(dir, Remove|Delete)
+}
+
+func TestRescan(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wsrescan")
+	if err != nil {
+		t.Fatal("failed to create temp dir")
+	}
+	defer os.RemoveAll(dir)
+	fail := func(msg string, err error) {
+		if err != nil {
+			t.Fatalf("%s: %s", msg, err)
+		}
+	}
+	fail("create old", ioutil.WriteFile(dir+"/old", nil, 0666))
+	fail("create kept", ioutil.WriteFile(dir+"/kept", nil, 0666))
+	fail("create changed", ioutil.WriteFile(dir+"/changed", nil, 0666))
+	old := time.Now().Add(-time.Hour)
+	fail("touch kept", os.Chtimes(dir+"/kept", old, old))
+	events := make(testhandler, 10)
+	w := New(Config{CapHint: 100, Handler: events})
+	defer w.Close()
+	_, err = w.Mount(dir)
+	fail("mount", err)
+	for len(events) > 0 {
+		<-events
+	}
+	since := time.Now().Add(-time.Minute)
+	fail("remove old", os.Remove(dir+"/old"))
+	fail("create new", os.Mkdir(dir+"/new", 0777))
+	fail("modify changed", ioutil.WriteFile(dir+"/changed", []byte("changed"), 0666))
+	fail("rescan", (*ctrl)(w).Rescan(since))
+	got := make(map[testevent]bool)
+	for len(events) > 0 {
+		got[<-events] = true
+	}
+	for _, e := range []testevent{
+		{Remove | Delete, dir + "/old"},
+		{Add | Create, dir + "/new"},
+		{Change | Create, dir + "/new"},
+		{Change | Modify, dir + "/changed"},
+	} {
+		if !got[e] {
+			t.Errorf("expected event %x %q", e.Op, e.Path)
+		}
+		delete(got, e)
+	}
+	for e := range got {
+		t.Errorf("unexpected event %x %q", e.Op, e.Path)
+	}
+	if r := w.Res(NewId(dir + "/changed")); r == nil || r.Size != 7 || r.ModTime.Before(since) {
+		t.Error("metadata not updated for modified file")
+	}
+	if r := w.Res(NewId(dir + "/kept")); r == nil || !r.ModTime.Equal(old) {
+		t.Error("metadata not read for mounted file")
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wssnapshot")
+	if err != nil {
+		t.Fatal("failed to create temp dir")
+	}
+	defer os.RemoveAll(dir)
+	fail := func(msg string, err error) {
+		if err != nil {
+			t.Fatalf("%s: %s", msg, err)
+		}
+	}
+	fail("mkdir a", os.Mkdir(dir+"/a", 0777))
+	fail("mkdir b", os.Mkdir(dir+"/b", 0777))
+	fail("create a/x", ioutil.WriteFile(dir+"/a/x", nil, 0666))
+	fail("create b/y", ioutil.WriteFile(dir+"/b/y", []byte("y"), 0666))
+	old := time.Now().Add(-time.Hour)
+	for _, path := range []string{dir + "/a", dir + "/b", dir} {
+		fail("touch", os.Chtimes(path, old, old))
+	}
+	w := New(Config{CapHint: 100})
+	_, err = w.Mount(dir)
+	fail("mount", err)
+	var buf bytes.Buffer
+	fail("write snapshot", w.WriteSnapshot(&buf))
+	w.Close()
+	snap, err := ReadSnapshot(&buf)
+	fail("read snapshot", err)
+
+	fail("remove a/x", os.Remove(dir+"/a/x"))
+	fail("create b/z", ioutil.WriteFile(dir+"/b/z", nil, 0666))
+	events := make(testhandler, 20)
+	w = New(Config{CapHint: 100, Handler: events, Snapshot: snap})
+	defer w.Close()
+	_, err = w.Mount(dir)
+	fail("mount snapshot", err)
+	if r := w.Res(NewId(dir + "/b/y")); r == nil || r.Size != 1 {
+		t.Error("metadata not loaded from snapshot")
+	}
+	expect := map[testevent]bool{
+		{Remove | Delete, dir + "/a/x"}: true,
+		{Add | Create, dir + "/b/z"}:    true,
+	}
+	timeout := time.After(time.Second)
+	for len(expect) > 0 {
+		select {
+		case e := <-events:
+			delete(expect, e)
+		case <-timeout:
+			for e := range expect {
+				t.Errorf("expected event %x %q", e.Op, e.Path)
+			}
+			return
+		}
+	}
+}
+
+func TestUnmount(t *testing.T) {
+	m := NewMemFS()
+	m.MkdirAll("/m/sub")
+	m.WriteFile("/m/sub/file", nil)
+	events := make(testhandler, 10)
+	w := New(Config{CapHint: 100, Handler: events})
+	defer w.Close()
+	if _, err := w.MountFS("/m", m); err != nil {
+		t.Fatal(err)
+	}
+	for len(events) > 0 {
+		<-events
+	}
+	if err := w.Unmount("/m"); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/m/sub/file", "/m/sub", "/m"} {
+		if e := <-events; e.Op != Remove || e.Path != path {
+			t.Errorf("expected event %x %q got %x %q\n", Remove, path, e.Op, e.Path)
+		}
+	}
+	if len(w.all) != 1 || len(w.root.Children) != 0 {
+		t.Errorf("logical parents not pruned: %v", w.root.Children)
+	}
+	if err := w.Unmount("/m"); err == nil {
+		t.Error("expected error unmounting twice")
+	}
+	// memfs reports changes before returning
+	m.WriteFile("/m/new", nil)
+	if len(events) > 0 {
+		e := <-events
+		t.Errorf("unexpected event %x %q", e.Op, e.Path)
+	}
+}
+
+func TestLinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wslinks")
+	if err != nil {
+		t.Fatal("failed to create temp dir")
+	}
+	defer os.RemoveAll(dir)
+	fail := func(msg string, err error) {
+		if err != nil {
+			t.Fatalf("%s: %s", msg, err)
+		}
+	}
+	fail("mkdir", os.Mkdir(dir+"/a", 0777))
+	fail("file", ioutil.WriteFile(dir+"/a/file", nil, 0666))
+	fail("link", os.Symlink(dir+"/a", dir+"/l"))
+	fail("loop", os.Symlink("..", dir+"/a/loop"))
+	flag := func(w *Ws, path string) uint64 {
+		r := w.Res(NewId(path))
+		if r == nil {
+			t.Fatalf("resource %s not found", path)
+		}
+		return r.Flag & (FlagDir | FlagLink | FlagIgnore)
+	}
+	w := New(Config{CapHint: 100})
+	_, err = w.Mount(dir)
+	fail("mount", err)
+	if f := flag(w, dir+"/l"); f != FlagLink {
+		t.Errorf("marked link flag %x", f)
+	}
+	w.Close()
+
+	w = New(Config{CapHint: 100, Links: LinkIgnore})
+	_, err = w.Mount(dir)
+	fail("mount", err)
+	if f := flag(w, dir+"/l"); f != FlagLink|FlagIgnore {
+		t.Errorf("ignored link flag %x", f)
+	}
+	w.Close()
+
+	events := make(testhandler, 20)
+	w = New(Config{CapHint: 100, Links: LinkFollow, Watcher: NewInotify, Handler: events})
+	defer w.Close()
+	_, err = w.Mount(dir)
+	fail("mount", err)
+	if f := flag(w, dir+"/l"); f != FlagDir|FlagLink {
+		t.Errorf("followed link flag %x", f)
+	}
+	if f := flag(w, dir+"/l/file"); f != 0 {
+		t.Errorf("file in followed link flag %x", f)
+	}
+	for _, path := range []string{dir + "/a/loop", dir + "/l/loop"} {
+		if f := flag(w, path); f != FlagLink {
+			t.Errorf("cyclic link %s flag %x", path, f)
+		}
+	}
+	for len(events) > 0 {
+		<-events
+	}
+	fail("create", ioutil.WriteFile(dir+"/a/new", nil, 0666))
+	got := make(map[testevent]bool)
+	for i := 0; i < 4; i++ {
+		select {
+		case e := <-events:
+			got[e] = true
+		case <-time.After(time.Second):
+			t.Fatal("timeout")
+		}
+	}
+	for _, path := range []string{dir + "/a/new", dir + "/l/new"} {
+		if !got[testevent{Add | Create, path}] || !got[testevent{Change | Modify, path}] {
+			t.Errorf("missing events for %s: %v", path, got)
+		}
+	}
+}
+
+func TestCollision(t *testing.T) {
+	w := New(Config{})
+	defer w.Close()
+	// force a collision of the ids of /a and /b
+	id := NewId("/a")
+	a := &Res{Id: id, Name: "a", Parent: w.root}
+	b := &Res{Id: id, Name: "b", Parent: w.root}
+	w.put(a)
+	w.put(b)
+	if w.Collisions() != 1 || w.all[id] != a {
+		t.Errorf("collision not detected")
+	}
+	if w.resPath("/a") != a || w.resPath("/b") != b {
+		t.Errorf("colliding resource not found by path")
+	}
+	w.drop(b)
+	if w.all[id] != a || w.resPath("/b") != nil {
+		t.Errorf("colliding resource dropped original")
+	}
+	c := &Res{Id: id, Name: "a", Parent: w.root}
+	w.put(c)
+	if w.Collisions() != 1 || w.all[id] != c {
+		t.Errorf("resource with same path not replaced")
+	}
+	w.put(b)
+	w.drop(c)
+	if w.Collisions() != 2 || w.all[id] != b || w.resPath("/a") != nil {
+		t.Errorf("colliding resource not promoted")
+	}
+}
+
 func TestSplitPath(t *testing.T) {
 	expect := []string{"c", "b", "a"}
 	for i, p := range split("/a/b/c") {